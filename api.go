@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type APISearchResult struct {
+	Title     string `json:"title"`
+	Snippet   string `json:"snippet"`
+	PageID    int    `json:"pageid"`
+	URL       string `json:"url"`
+	WordCount int    `json:"wordcount"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+type APISearchResponse struct {
+	Query         string            `json:"query"`
+	CorrectedFrom string            `json:"corrected_from,omitempty"`
+	Stale         bool              `json:"stale,omitempty"`
+	TotalPages    int               `json:"total_pages"`
+	CurrentPage   int               `json:"current_page"`
+	Results       []APISearchResult `json:"results"`
+}
+
+type APIError struct {
+	Error         string `json:"error"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// newAPIError builds the JSON body for err. Like renderError, it only
+// exposes the error itself in development.
+func newAPIError(err error, correlationID string) APIError {
+	apiErr := APIError{
+		Error:         errorMessage(errorStatus(err)),
+		CorrelationID: correlationID,
+	}
+
+	if isDevelopment {
+		apiErr.Error = err.Error()
+	}
+
+	return apiErr
+}
+
+type apiHandlerWithError func(w http.ResponseWriter, r *http.Request) error
+
+func (fn apiHandlerWithError) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := fn(w, r)
+	if err != nil {
+		correlationID := CorrelationIDFromCtx(r.Context())
+
+		httpLog.Logf(errorLogLevel(err), "%s (%s)", redact(err.Error()), logFields(r.Context()))
+		setRetryAfter(w, err)
+		writeJSON(w, errorStatus(err), newAPIError(err, correlationID))
+
+		return
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (app *application) apiSearchHandler(w http.ResponseWriter, r *http.Request) error {
+	search, err := app.runSearch(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodHead {
+		return writeHead(w, "application/json")
+	}
+
+	setSearchDebugHeaders(w, search)
+
+	return writeJSON(w, http.StatusOK, newAPISearchResponse(search))
+}
+
+func articleURL(lang string, pageID int) string {
+	return fmt.Sprintf("https://%s.wikipedia.org?curid=%d", lang, pageID)
+}
+
+func newAPISearchResponse(search *Search) APISearchResponse {
+	resp := APISearchResponse{
+		Query:         search.Query,
+		CorrectedFrom: search.CorrectedFrom,
+		Stale:         search.Stale,
+		TotalPages:    search.TotalPages,
+		CurrentPage:   search.CurrentPage(),
+		Results:       []APISearchResult{},
+	}
+
+	for _, result := range search.Results.Query.Search {
+		resp.Results = append(resp.Results, APISearchResult{
+			Title:     result.Title,
+			Snippet:   string(search.Snippet(result.Snippet)),
+			PageID:    result.PageID,
+			URL:       result.URL(search.Lang),
+			WordCount: result.WordCount,
+			Thumbnail: result.Thumbnail,
+		})
+	}
+
+	return resp
+}
+
+// negotiateContent serves the JSON handler to clients that prefer
+// application/json over text/html in their Accept header, and the HTML
+// handler to everyone else.
+func negotiateContent(html, json http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+
+		if prefersJSON(r.Header.Get("Accept")) {
+			json.ServeHTTP(w, r)
+			return
+		}
+
+		html.ServeHTTP(w, r)
+	})
+}
+
+func prefersJSON(accept string) bool {
+	return acceptQuality(accept, "application/json") >
+		acceptQuality(accept, "text/html")
+}
+
+// acceptQuality returns the q-value the Accept header assigns to
+// mediaType, taking the most specific matching range into account.
+func acceptQuality(accept, mediaType string) float64 {
+	typ, _, _ := strings.Cut(mediaType, "/")
+
+	quality, specificity := 0.0, -1
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+
+		var s int
+		switch mediaRange {
+		case mediaType:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+
+		if s < specificity {
+			continue
+		}
+
+		quality, specificity = qValue(params), s
+	}
+
+	return quality
+}
+
+// qValue returns the q parameter from the parameters of one element of an
+// Accept-style header, defaulting to 1.
+func qValue(params string) float64 {
+	q := 1.0
+
+	for _, param := range strings.Split(params, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok && strings.TrimSpace(key) == "q" {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = v
+			}
+		}
+	}
+
+	return q
+}
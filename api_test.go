@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPISearchResponseSanitizesSnippets(t *testing.T) {
+	const snippet = `<script>alert(1)</script>about <span class="searchmatch">go</span> &lt;b&gt;`
+
+	for _, highlight := range []bool{true, false} {
+		search := &Search{Highlight: highlight, Lang: defaultLanguage, Results: &WikipediaSearchResponse{}}
+		search.Results.Query.Search = []WikipediaSearchResult{{Title: "Go", PageID: 1, Snippet: snippet}}
+
+		got := newAPISearchResponse(search).Results[0].Snippet
+
+		if strings.Contains(got, "<script") || strings.Contains(got, "<b>") {
+			t.Errorf("highlight=%t: snippet %q contains unsafe markup", highlight, got)
+		}
+
+		if hasMatch := strings.Contains(got, `<span class="searchmatch">go</span>`); hasMatch != highlight {
+			t.Errorf("highlight=%t: snippet %q, want search match span %t", highlight, got, highlight)
+		}
+	}
+}
+
+func TestAPISearchHandlerHidesErrorDetail(t *testing.T) {
+	t.Cleanup(func() { isDevelopment = false })
+
+	for _, development := range []bool{false, true} {
+		t.Run(fmt.Sprintf("development=%t", development), func(t *testing.T) {
+			app := newTestApp(t, newFakeWikipedia(t))
+			isDevelopment = development
+
+			rec := httptest.NewRecorder()
+			apiHandlerWithError(app.apiSearchHandler).ServeHTTP(
+				rec,
+				httptest.NewRequest(http.MethodGet, "/api/search?q=unknown", nil),
+			)
+
+			if rec.Code < 500 {
+				t.Errorf("status = %d, want a 5xx", rec.Code)
+			}
+
+			var apiErr APIError
+			if err := json.NewDecoder(rec.Body).Decode(&apiErr); err != nil {
+				t.Fatal(err)
+			}
+
+			if leaked := strings.Contains(apiErr.Error, "boom"); leaked != development {
+				t.Errorf("error = %q, want upstream detail %t", apiErr.Error, development)
+			}
+		})
+	}
+}
+
+func TestPrefersJSON(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"text/html", false},
+		{"application/json", true},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"application/json, text/html;q=0.5", true},
+		{"application/*", true},
+		{"*/*", false},
+	}
+
+	for _, tt := range tests {
+		if got := prefersJSON(tt.accept); got != tt.want {
+			t.Errorf("prefersJSON(%q) = %t, want %t", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestSearchNegotiatesContent(t *testing.T) {
+	app := newTestApp(t, newFakeWikipedia(t))
+	handler := negotiateContent(
+		handlerWithError(app.searchHandler),
+		apiHandlerWithError(app.apiSearchHandler),
+	)
+
+	t.Run("text/html", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/search?q=golang", nil)
+		req.Header.Set("Accept", "text/html")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+			t.Errorf("Content-Type = %q, want text/html", got)
+		}
+
+		if !strings.Contains(rec.Body.String(), "golang result 1<") {
+			t.Error("HTML response is missing the first result")
+		}
+	})
+
+	t.Run("application/json", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/search?q=golang", nil)
+		req.Header.Set("Accept", "application/json")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", got)
+		}
+
+		var resp APISearchResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Query != "golang" || len(resp.Results) != defaultPageSize || resp.Results[0].Title != "golang result 1" {
+			t.Errorf("response = %+v, want the first page of golang results", resp)
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=golang", nil))
+
+	if got := rec.Header().Get("Vary"); !strings.Contains(got, "Accept") {
+		t.Errorf("Vary = %q, want it to include Accept", got)
+	}
+}
+
+func TestReadyHandlerHidesUpstreamError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	buf := captureLog(t)
+	app := &application{wiki: NewWikipediaClient(srv.Client(), srv.URL)}
+
+	rec := httptest.NewRecorder()
+	app.readyHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	var got healthStatus
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Error != "wikipedia unreachable" {
+		t.Errorf("error = %q, want %q", got.Error, "wikipedia unreachable")
+	}
+
+	if !strings.Contains(buf.String(), "502 Bad Gateway") {
+		t.Errorf("log = %q, want the upstream status", buf.String())
+	}
+}
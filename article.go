@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type WikipediaExtractResponse struct {
+	Error *WikipediaAPIError `json:"error"`
+	Query struct {
+		Pages map[string]struct {
+			PageID       int     `json:"pageid"`
+			Title        string  `json:"title"`
+			Extract      string  `json:"extract"`
+			CanonicalURL string  `json:"canonicalurl"`
+			Missing      *string `json:"missing"`
+			Invalid      *string `json:"invalid"`
+		} `json:"pages"`
+	} `json:"query"`
+}
+
+type Article struct {
+	PageID       int
+	Lang         string
+	Title        string
+	Extract      string
+	CanonicalURL string
+}
+
+func (a *Article) Paragraphs() []string {
+	var paragraphs []string
+
+	for _, p := range strings.Split(a.Extract, "\n") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+
+	return paragraphs
+}
+
+func (c *WikipediaClient) Article(
+	ctx context.Context,
+	lang string,
+	pageID int,
+) (*Article, error) {
+	endpoint := c.endpoint(lang, url.Values{
+		"action":      {"query"},
+		"prop":        {"extracts|info"},
+		"exintro":     {""},
+		"explaintext": {""},
+		"inprop":      {"url"},
+		"format":      {"json"},
+		"origin":      {"*"},
+		"pageids":     {strconv.Itoa(pageID)},
+	})
+
+	err := waitForRateLimit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"non 200 OK response from Wikipedia API: %s",
+			resp.Status,
+		)
+	}
+
+	var extractResponse WikipediaExtractResponse
+
+	err = json.NewDecoder(resp.Body).Decode(&extractResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	if extractResponse.Error != nil {
+		return nil, extractResponse.Error
+	}
+
+	for _, page := range extractResponse.Query.Pages {
+		if page.PageID <= 0 || page.Missing != nil || page.Invalid != nil {
+			break
+		}
+
+		return &Article{
+			PageID:       page.PageID,
+			Lang:         lang,
+			Title:        page.Title,
+			Extract:      page.Extract,
+			CanonicalURL: page.CanonicalURL,
+		}, nil
+	}
+
+	return nil, newStatusError(
+		http.StatusNotFound,
+		fmt.Errorf("no article found with page ID %d", pageID),
+	)
+}
+
+func (app *application) articleHandler(w http.ResponseWriter, r *http.Request) error {
+	params := r.URL.Query()
+
+	pageID, err := strconv.Atoi(params.Get("pageid"))
+	if err != nil || pageID <= 0 {
+		return newStatusError(
+			http.StatusBadRequest,
+			fmt.Errorf("invalid page ID '%s'", params.Get("pageid")),
+		)
+	}
+
+	lang := params.Get("lang")
+	if !wikipediaLanguages[lang] {
+		lang = defaultLanguage
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), searchTimeout)
+	defer cancel()
+
+	article, err := app.wiki.Article(ctx, lang, pageID)
+	if err != nil {
+		var statusErr StatusError
+		if errors.As(err, &statusErr) {
+			return err
+		}
+
+		if errors.Is(err, context.DeadlineExceeded) {
+			return newStatusError(http.StatusGatewayTimeout, err)
+		}
+
+		return newStatusError(http.StatusBadGateway, err)
+	}
+
+	return renderTemplate(w, r, "article", http.StatusOK, article)
+}
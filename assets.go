@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	assetsDir = "assets"
+
+	fingerprintedAssetCacheControl = "public, max-age=31536000, immutable"
+	assetCacheControl              = "public, max-age=3600"
+)
+
+// assetFingerprints maps asset names such as "style.css" to content-hashed
+// names such as "style.1a2b3c4d.css", and fingerprintedAssets maps them back.
+// Templates link to the hashed names, so a changed file gets a new URL and
+// can be cached forever.
+var (
+	assetFingerprints   = map[string]string{}
+	fingerprintedAssets = map[string]string{}
+)
+
+func loadAssetFingerprints(dir string) error {
+	return fs.WalkDir(os.DirFS(dir), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		b, err := os.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(b)
+		ext := path.Ext(name)
+		hashed := strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+
+		assetFingerprints[name] = hashed
+		fingerprintedAssets[hashed] = name
+
+		return nil
+	})
+}
+
+// assetPath is available to templates as "asset" and returns the URL of the
+// fingerprinted version of an asset.
+func assetPath(name string) string {
+	if hashed, ok := assetFingerprints[name]; ok {
+		return "/assets/" + hashed
+	}
+
+	return "/assets/" + name
+}
+
+// assetCacheMiddleware serves fingerprinted asset paths from the underlying
+// files with a far-future expiry, and plain paths with a shorter TTL. It
+// expects the /assets/ prefix to have been stripped already.
+func assetCacheMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name, ok := fingerprintedAssets[strings.TrimPrefix(r.URL.Path, "/")]; ok {
+			w.Header().Set("Cache-Control", fingerprintedAssetCacheControl)
+			r.URL.Path = name
+		} else {
+			w.Header().Set("Cache-Control", assetCacheControl)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
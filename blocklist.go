@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var errQueryBlocked = errors.New("this query is not allowed")
+
+// queryBlocklist matches search queries that contain a blocked term as a
+// whole word, ignoring case. It is nil, and nothing is blocked, unless
+// QUERY_BLOCKLIST or QUERY_BLOCKLIST_FILE is set.
+var queryBlocklist *regexp.Regexp
+
+// compileBlocklist builds a single case-insensitive matcher for terms. Terms
+// are matched literally and may contain spaces to block a phrase.
+func compileBlocklist(terms []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(terms))
+
+	for _, term := range terms {
+		term = strings.Join(strings.Fields(term), " ")
+		if term != "" {
+			quoted = append(quoted, regexp.QuoteMeta(term))
+		}
+	}
+
+	if len(quoted) == 0 {
+		return nil
+	}
+
+	return regexp.MustCompile(
+		`(?i)(?:^|[^\pL\pN])(?:` + strings.Join(quoted, "|") + `)(?:[^\pL\pN]|$)`,
+	)
+}
+
+// loadBlocklist reads blocked terms from the comma-separated terms list and
+// from path, which holds one term per line with '#' starting a comment.
+func loadBlocklist(terms, path string) *regexp.Regexp {
+	list := strings.Split(terms, ",")
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Unable to read query blocklist: %v", err)
+		}
+
+		for _, line := range strings.Split(string(data), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			list = append(list, line)
+		}
+	}
+
+	return compileBlocklist(list)
+}
+
+func queryBlocked(query string) bool {
+	if queryBlocklist == nil {
+		return false
+	}
+
+	return queryBlocklist.MatchString(strings.Join(strings.Fields(query), " "))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerTimeout  = 30 * time.Second
+)
+
+var breakerTimeout = defaultBreakerTimeout
+
+var wikipediaBreaker = newWikipediaBreaker(
+	defaultBreakerFailures,
+	defaultBreakerTimeout,
+)
+
+// newWikipediaBreaker returns a circuit breaker that opens after the given
+// number of consecutive upstream failures and stays open for timeout before
+// letting a trial request through.
+func newWikipediaBreaker(failures int, timeout time.Duration) *gobreaker.CircuitBreaker {
+	return gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:    "wikipedia",
+		Timeout: timeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= uint32(failures)
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			wikiLog.Warnf("Circuit breaker '%s' changed from %s to %s", name, from, to)
+			circuitBreakerState.Set(float64(to))
+		},
+	})
+}
+
+type circuitOpenError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return "Wikipedia API is temporarily unavailable: " + e.err.Error()
+}
+
+func (e *circuitOpenError) Unwrap() error {
+	return e.err
+}
+
+func (e *circuitOpenError) Status() int {
+	return http.StatusServiceUnavailable
+}
+
+func (e *circuitOpenError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+func breakerError(err error, retryAfter time.Duration) error {
+	if errors.Is(err, gobreaker.ErrOpenState) ||
+		errors.Is(err, gobreaker.ErrTooManyRequests) {
+		return &circuitOpenError{err: err, retryAfter: retryAfter}
+	}
+
+	return err
+}
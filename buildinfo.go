@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+type buildMetadata struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Revision  string `json:"vcs_revision,omitempty"`
+	Time      string `json:"vcs_time,omitempty"`
+	Modified  bool   `json:"vcs_modified"`
+}
+
+// buildInfo is read once at startup. The VCS fields are empty when the
+// binary carries no build info, as can happen with go run.
+var buildInfo = readBuildMetadata()
+
+// readBuildInfo is a variable so tests can simulate a binary without build
+// info.
+var readBuildInfo = debug.ReadBuildInfo
+
+func readBuildMetadata() buildMetadata {
+	metadata := buildMetadata{
+		Version:   appVersion,
+		GoVersion: runtime.Version(),
+	}
+
+	info, ok := readBuildInfo()
+	if !ok {
+		return metadata
+	}
+
+	metadata.GoVersion = info.GoVersion
+
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			metadata.Revision = setting.Value
+		case "vcs.time":
+			metadata.Time = setting.Value
+		case "vcs.modified":
+			metadata.Modified = setting.Value == "true"
+		}
+	}
+
+	return metadata
+}
+
+// logBuildInfo logs the version, Go toolchain and VCS details the binary was
+// built with, so log output can be tied back to a specific build.
+func logBuildInfo() {
+	logInfof(
+		"Build info: version=%s go_version=%s vcs_revision=%s vcs_time=%s vcs_modified=%t",
+		buildInfo.Version,
+		buildInfo.GoVersion,
+		buildInfo.Revision,
+		buildInfo.Time,
+		buildInfo.Modified,
+	)
+}
+
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildInfo)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestReadBuildMetadataWithoutBuildInfo(t *testing.T) {
+	savedRead, savedInfo := readBuildInfo, buildInfo
+	t.Cleanup(func() { readBuildInfo, buildInfo = savedRead, savedInfo })
+
+	readBuildInfo = func() (*debug.BuildInfo, bool) { return nil, false }
+	buildInfo = readBuildMetadata()
+
+	want := buildMetadata{Version: appVersion, GoVersion: runtime.Version()}
+	if buildInfo != want {
+		t.Errorf("readBuildMetadata() = %+v, want %+v", buildInfo, want)
+	}
+
+	buf := captureLog(t)
+	logBuildInfo()
+
+	if !strings.Contains(buf.String(), "go_version="+runtime.Version()+" ") {
+		t.Errorf("build info log = %q, want go_version=%s", buf.String(), runtime.Version())
+	}
+
+	if got, want := buildUserAgent("ops@example.com"), "wikipedia-demo/1.0 (ops@example.com)"; got != want {
+		t.Errorf("buildUserAgent() = %q, want %q", got, want)
+	}
+}
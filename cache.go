@@ -0,0 +1,137 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheTTL      = 5 * time.Minute
+	defaultCacheStaleTTL = time.Hour
+	defaultCacheSize     = 512
+)
+
+type searchCacheKey struct {
+	Query         string
+	PageSize      int
+	ResultsOffset int
+	Lang          string
+	Sort          string
+	Namespace     string
+}
+
+func (k searchCacheKey) String() string {
+	return fmt.Sprintf(
+		"%q|%d|%d|%s|%s|%s",
+		k.Query,
+		k.PageSize,
+		k.ResultsOffset,
+		k.Lang,
+		k.Sort,
+		k.Namespace,
+	)
+}
+
+type searchCacheEntry struct {
+	key       searchCacheKey
+	response  *WikipediaSearchResponse
+	expiresAt time.Time
+}
+
+// SearchCache is an LRU cache of Wikipedia search responses whose entries
+// expire after a fixed TTL. Expired entries are kept for a further staleTTL
+// so they can stand in for fresh results while Wikipedia is failing.
+type SearchCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	staleTTL   time.Duration
+	maxEntries int
+	ll         *list.List
+	items      map[searchCacheKey]*list.Element
+}
+
+func NewSearchCache(ttl, staleTTL time.Duration, maxEntries int) *SearchCache {
+	return &SearchCache{
+		ttl:        ttl,
+		staleTTL:   staleTTL,
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[searchCacheKey]*list.Element),
+	}
+}
+
+func (c *SearchCache) Get(key searchCacheKey) (*WikipediaSearchResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*searchCacheEntry)
+	if now := time.Now(); now.After(entry.expiresAt) {
+		if now.After(entry.expiresAt.Add(c.staleTTL)) {
+			c.removeElement(el)
+		}
+
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+
+	return entry.response, true
+}
+
+// GetStale returns the response cached for key even if it has expired, as
+// long as it is still within the stale TTL.
+func (c *SearchCache) GetStale(key searchCacheKey) (*WikipediaSearchResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*searchCacheEntry)
+	if time.Now().After(entry.expiresAt.Add(c.staleTTL)) {
+		c.removeElement(el)
+		return nil, false
+	}
+
+	return entry.response, true
+}
+
+func (c *SearchCache) Set(key searchCacheKey, response *WikipediaSearchResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := time.Now().Add(c.ttl)
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*searchCacheEntry)
+		entry.response = response
+		entry.expiresAt = expiresAt
+		c.ll.MoveToFront(el)
+
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&searchCacheEntry{
+		key:       key,
+		response:  response,
+		expiresAt: expiresAt,
+	})
+
+	for c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *SearchCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*searchCacheEntry).key)
+}
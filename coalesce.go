@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// searchGroup merges concurrent cache misses for the same search into a
+// single upstream call.
+var searchGroup singleflight.Group
+
+type fetchResult struct {
+	response *WikipediaSearchResponse
+	elapsed  time.Duration
+}
+
+// detachedContext keeps the values of the request that started a shared
+// search, such as its correlation ID and trace, but not its cancellation, so
+// one caller going away doesn't fail the search for everyone waiting on it.
+// The search is cancelled through sharedSearch once nobody is waiting.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// sharedSearch counts the callers waiting on a search in searchGroup.
+type sharedSearch struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
+}
+
+var (
+	sharedSearchesMu sync.Mutex
+	sharedSearches   = make(map[string]*sharedSearch)
+)
+
+// joinSharedSearch registers a caller waiting on the search for name, setting
+// up the context the upstream call runs with if it is the first.
+func joinSharedSearch(ctx context.Context, name string) *sharedSearch {
+	sharedSearchesMu.Lock()
+	defer sharedSearchesMu.Unlock()
+
+	search, ok := sharedSearches[name]
+	if !ok {
+		searchCtx, cancel := context.WithCancel(detachedContext{ctx})
+		search = &sharedSearch{ctx: searchCtx, cancel: cancel}
+		sharedSearches[name] = search
+	}
+
+	search.waiters++
+
+	return search
+}
+
+// leaveSharedSearch removes a caller from the search for name. When the last
+// one leaves, the upstream call is cancelled and forgotten so a later search
+// for the same key starts afresh rather than joining the cancelled one.
+func leaveSharedSearch(name string, search *sharedSearch) {
+	sharedSearchesMu.Lock()
+	defer sharedSearchesMu.Unlock()
+
+	search.waiters--
+	if search.waiters > 0 {
+		return
+	}
+
+	search.cancel()
+	delete(sharedSearches, name)
+	searchGroup.Forget(name)
+}
+
+// coalescedSearch fetches key from Wikipedia, sharing the call with any
+// identical search already in flight. Errors are returned to every waiting
+// caller but never cached, and each caller gets its own copy of the response.
+// The upstream call is abandoned once every caller waiting on it has gone.
+func (app *application) coalescedSearch(
+	ctx context.Context,
+	key searchCacheKey,
+) (*WikipediaSearchResponse, time.Duration, error) {
+	name := key.String()
+
+	shared := joinSharedSearch(ctx, name)
+	defer leaveSharedSearch(name, shared)
+
+	ch := searchGroup.DoChan(name, func() (any, error) {
+		response, elapsed, err := app.fetchSearch(shared.ctx, key)
+
+		return fetchResult{response: response, elapsed: elapsed}, err
+	})
+
+	select {
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, 0, newStatusError(
+				http.StatusGatewayTimeout,
+				fmt.Errorf("search timed out: %w", ctx.Err()),
+			)
+		}
+
+		return nil, 0, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, 0, res.Err
+		}
+
+		if res.Shared {
+			wikiLog.Debugf(
+				"Shared an in-flight Wikipedia search for query '%s' (%s)",
+				redact(key.Query),
+				logFields(ctx),
+			)
+		}
+
+		result := res.Val.(fetchResult)
+
+		return copySearchResponse(result.response), result.elapsed, nil
+	}
+}
+
+func copySearchResponse(response *WikipediaSearchResponse) *WikipediaSearchResponse {
+	c := *response
+	c.Query.Search = append([]WikipediaSearchResult(nil), response.Query.Search...)
+
+	return &c
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gateSearches makes fake hold searches until the returned function is
+// called, which the test's cleanup also does in case it fails first.
+func gateSearches(t *testing.T, fake *fakeWikipedia) (release func()) {
+	t.Helper()
+
+	fake.searchGate = make(chan struct{})
+
+	var once sync.Once
+	release = func() { once.Do(func() { close(fake.searchGate) }) }
+	t.Cleanup(release)
+
+	return release
+}
+
+// waitFor polls cond until it holds, failing the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// sharedSearchWaiters returns the number of callers waiting on shared
+// searches.
+func sharedSearchWaiters() int {
+	sharedSearchesMu.Lock()
+	defer sharedSearchesMu.Unlock()
+
+	waiters := 0
+	for _, search := range sharedSearches {
+		waiters += search.waiters
+	}
+
+	return waiters
+}
+
+func TestCoalescedSearchConcurrentCallers(t *testing.T) {
+	fake := newFakeWikipedia(t)
+	release := gateSearches(t, fake)
+	app := newTestApp(t, fake)
+
+	const callers = 10
+
+	var wg sync.WaitGroup
+
+	searches := make([]*Search, callers)
+	errs := make([]error, callers)
+
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			searches[i], errs[i] = app.runSearch(httptest.NewRequest(http.MethodGet, "/search?q=golang", nil))
+		}(i)
+	}
+
+	waitFor(t, "all callers to wait on the search", func() bool {
+		return sharedSearchWaiters() == callers
+	})
+	release()
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("caller %d: %v", i, err)
+		}
+	}
+
+	if got := fake.searches.Load(); got != 1 {
+		t.Errorf("upstream searches = %d, want 1", got)
+	}
+
+	searches[0].Results.Query.Search[0].Title = "changed"
+
+	for i, search := range searches[1:] {
+		if got := search.Results.Query.Search[0].Title; got != "golang result 1" {
+			t.Errorf("caller %d: first title = %q, want each caller to get its own copy", i+1, got)
+		}
+	}
+}
+
+func TestCoalescedSearchCancelledWhenCallersLeave(t *testing.T) {
+	fake := newFakeWikipedia(t)
+	release := gateSearches(t, fake)
+	app := newTestApp(t, fake)
+
+	search := func(ctx context.Context) <-chan error {
+		errc := make(chan error, 1)
+
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, "/search?q=golang", nil).WithContext(ctx)
+			_, err := app.runSearch(req)
+			errc <- err
+		}()
+
+		return errc
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	errc1 := search(ctx1)
+	errc2 := search(ctx2)
+	waitFor(t, "both callers to wait on the upstream search", func() bool {
+		return sharedSearchWaiters() == 2 && fake.searches.Load() == 1
+	})
+
+	cancel1()
+
+	if err := <-errc1; !errors.Is(err, context.Canceled) {
+		t.Errorf("first caller error = %v, want context.Canceled", err)
+	}
+
+	waitFor(t, "the first caller to leave", func() bool {
+		return sharedSearchWaiters() == 1
+	})
+
+	if got := fake.cancelled.Load(); got != 0 {
+		t.Fatalf("upstream search cancelled while a caller was still waiting")
+	}
+
+	cancel2()
+
+	if err := <-errc2; !errors.Is(err, context.Canceled) {
+		t.Errorf("second caller error = %v, want context.Canceled", err)
+	}
+
+	waitFor(t, "the upstream search to be cancelled", func() bool {
+		return fake.cancelled.Load() == 1
+	})
+
+	// A new search mustn't join the abandoned call.
+	release()
+
+	_, err := app.runSearch(httptest.NewRequest(http.MethodGet, "/search?q=golang", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fake.searches.Load(); got != 2 {
+		t.Errorf("upstream searches = %d, want 2", got)
+	}
+}
+
+func TestCoalescedSearchTimeoutCancelsUpstream(t *testing.T) {
+	fake := newFakeWikipedia(t)
+	gateSearches(t, fake)
+	app := newTestApp(t, fake)
+
+	handler := timeoutMiddleware(handlerWithError(app.searchHandler), 50*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=golang", nil))
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+
+	waitFor(t, "the upstream search to be cancelled", func() bool {
+		return fake.cancelled.Load() == 1
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxConcurrentSearches = 50
+	searchSlotRetryAfter         = time.Second
+)
+
+// searchSlots bounds the number of searches waiting on Wikipedia at once.
+// It is nil, and searches are unbounded, when MAX_CONCURRENT_SEARCHES is 0.
+var searchSlots = newSearchSlots(defaultMaxConcurrentSearches)
+
+func newSearchSlots(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+
+	return make(chan struct{}, n)
+}
+
+type searchesSaturatedError struct {
+	limit int
+}
+
+func (e *searchesSaturatedError) Error() string {
+	return fmt.Sprintf("all %d concurrent search slots are in use", e.limit)
+}
+
+func (e *searchesSaturatedError) Status() int {
+	return http.StatusServiceUnavailable
+}
+
+func (e *searchesSaturatedError) RetryAfter() time.Duration {
+	return searchSlotRetryAfter
+}
+
+// acquireSearchSlot waits for a free search slot until ctx is done. Running
+// out of time means the process is saturated and yields a 503; a client
+// disconnect returns the context error as is. Every successful call must be
+// paired with releaseSearchSlot.
+func acquireSearchSlot(ctx context.Context) error {
+	if searchSlots == nil {
+		return nil
+	}
+
+	select {
+	case searchSlots <- struct{}{}:
+		searchesInFlight.Inc()
+		return nil
+	default:
+	}
+
+	wikiLog.Warnf(
+		"All %d search slots are in use, waiting for one to free up (%s)",
+		cap(searchSlots),
+		logFields(ctx),
+	)
+
+	select {
+	case searchSlots <- struct{}{}:
+		// A slot can free up just as the deadline passes; starting a search
+		// with no time left would only turn the wait into a 504.
+		if ctx.Err() == nil {
+			searchesInFlight.Inc()
+			return nil
+		}
+
+		<-searchSlots
+	case <-ctx.Done():
+	}
+
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ctx.Err()
+	}
+
+	return &searchesSaturatedError{limit: cap(searchSlots)}
+}
+
+func releaseSearchSlot() {
+	if searchSlots == nil {
+		return
+	}
+
+	<-searchSlots
+	searchesInFlight.Dec()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+var corsAllowedOrigins = []string{"*"}
+
+func parseOrigins(s string) []string {
+	var origins []string
+
+	for _, origin := range strings.Split(s, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+
+	return origins
+}
+
+func allowedOrigin(origin string) string {
+	for _, allowed := range corsAllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+
+	return ""
+}
+
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+
+		allowed := allowedOrigin(origin)
+		if allowed != "" {
+			h.Set("Access-Control-Allow-Origin", allowed)
+			h.Set("Access-Control-Expose-Headers", "X-Correlation-ID, Retry-After, X-Search-Duration-Ms, X-Total-Hits")
+		}
+
+		if r.Method == http.MethodOptions &&
+			r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed != "" {
+				h.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+				h.Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-Correlation-ID")
+				h.Set("Access-Control-Max-Age", "600")
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
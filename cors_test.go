@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	t.Cleanup(func() { corsAllowedOrigins = []string{"*"} })
+
+	tests := []struct {
+		name        string
+		allowed     string
+		origin      string
+		wantOrigin  string
+		wantMethods string
+	}{
+		{"any origin", "*", "https://example.com", "*", "GET, OPTIONS"},
+		{"allowlisted origin", "https://a.example, https://b.example", "https://B.example", "https://B.example", "GET, OPTIONS"},
+		{"other origin", "https://a.example", "https://evil.example", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corsAllowedOrigins = parseOrigins(tt.allowed)
+
+			called := false
+			handler := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+
+			req := httptest.NewRequest(http.MethodOptions, "/api/search", nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNoContent {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusNoContent)
+			}
+
+			if called {
+				t.Error("preflight request reached the handler")
+			}
+
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("Access-Control-Allow-Methods = %q, want %q", got, tt.wantMethods)
+			}
+		})
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	t.Cleanup(func() { corsAllowedOrigins = []string{"*"} })
+	corsAllowedOrigins = parseOrigins("https://a.example")
+
+	app := newTestApp(t, newFakeWikipedia(t))
+	handler := corsMiddleware(apiHandlerWithError(app.apiSearchHandler))
+
+	tests := []struct {
+		origin     string
+		wantOrigin string
+	}{
+		{"https://a.example", "https://a.example"},
+		{"https://evil.example", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/search?q=golang", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("origin %q: status = %d, want %d", tt.origin, rec.Code, http.StatusOK)
+		}
+
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+			t.Errorf("origin %q: Access-Control-Allow-Origin = %q, want %q", tt.origin, got, tt.wantOrigin)
+		}
+
+		if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "" {
+			t.Errorf("origin %q: Access-Control-Allow-Methods = %q on a non-preflight request", tt.origin, got)
+		}
+	}
+}
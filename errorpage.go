@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// isDevelopment is set from APP_ENV=development and exposes the underlying
+// error on error pages.
+var isDevelopment bool
+
+var errorMessages = map[int]string{
+	http.StatusBadRequest:         "That search couldn't be understood. Check the page number and filters, then try again.",
+	http.StatusForbidden:          "This query is not allowed. Please try a different search.",
+	http.StatusNotFound:           "We couldn't find what you were looking for.",
+	http.StatusTooManyRequests:    "Too many requests are being made right now. Please try again shortly.",
+	http.StatusBadGateway:         "Wikipedia returned an unexpected response. Please try again.",
+	http.StatusServiceUnavailable: "Wikipedia is temporarily unavailable. Please try again shortly.",
+	http.StatusGatewayTimeout:     "Wikipedia took too long to respond. Please try again.",
+}
+
+const defaultErrorMessage = "Something went wrong on our end. Please try again."
+
+// errorMessage returns the user-facing message for status.
+func errorMessage(status int) string {
+	if msg, ok := errorMessages[status]; ok {
+		return msg
+	}
+
+	return defaultErrorMessage
+}
+
+type errorPage struct {
+	Status        int
+	StatusText    string
+	Message       string
+	CorrelationID string
+	Detail        string
+}
+
+// renderError writes an HTML error page for err. The error itself is only
+// shown in development; elsewhere users get a generic message for the status
+// and the correlation ID to quote.
+func renderError(w http.ResponseWriter, err error, correlationID string) {
+	status := errorStatus(err)
+
+	page := errorPage{
+		Status:        status,
+		StatusText:    http.StatusText(status),
+		Message:       errorMessage(status),
+		CorrelationID: correlationID,
+	}
+
+	if isDevelopment {
+		page.Detail = err.Error()
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	t, tplErr := pageTemplate("error")
+	if tplErr == nil {
+		tplErr = t.Execute(buf, page)
+	}
+
+	if tplErr != nil {
+		logErrorf("Unable to render error page: %v (correlation_id=%s)", tplErr, correlationID)
+		http.Error(
+			w,
+			fmt.Sprintf("%s\n\nCorrelation ID: %s", page.Message, correlationID),
+			status,
+		)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	buf.WriteTo(w)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const readinessTimeout = 2 * time.Second
+
+type healthStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+func (app *application) readyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	err := app.wiki.Ping(ctx)
+	if err != nil {
+		httpLog.Warnf(
+			"Readiness check failed: %s (%s)",
+			redact(err.Error()),
+			logFields(r.Context()),
+		)
+
+		// The underlying error can name internal hosts and addresses, so it
+		// stays in the log.
+		writeJSON(w, http.StatusServiceUnavailable, healthStatus{
+			Status: "unavailable",
+			Error:  "wikipedia unreachable",
+		})
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+func (c *WikipediaClient) Ping(ctx context.Context) error {
+	req, err := c.newRequest(
+		ctx,
+		http.MethodHead,
+		c.endpoint(defaultLanguage, nil),
+	)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("Wikipedia API responded with %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	historyCookieName    = "searchHistory"
+	historyCookieMaxAge  = 30 * 24 * time.Hour
+	maxHistoryCookieSize = 2048
+
+	defaultSearchHistorySize = 5
+)
+
+// searchHistorySize is the number of recent queries remembered per browser.
+// Zero disables search history.
+var searchHistorySize = defaultSearchHistorySize
+
+// historySecret keys the HMAC that signs the history cookie. It is replaced
+// from SEARCH_HISTORY_SECRET at startup and otherwise shares the visitor ID
+// secret.
+var historySecret []byte
+
+func signHistory(value string) string {
+	mac := hmac.New(sha256.New, historySecret)
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// searchHistory returns the recent queries stored in the signed cookie on r,
+// most recent first. A missing, malformed or tampered cookie yields none.
+func searchHistory(r *http.Request) []string {
+	if searchHistorySize == 0 {
+		return nil
+	}
+
+	cookie, err := r.Cookie(historyCookieName)
+	if err != nil {
+		return nil
+	}
+
+	value, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signHistory(value))) {
+		return nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+
+	var queries []string
+
+	err = json.Unmarshal(data, &queries)
+	if err != nil {
+		return nil
+	}
+
+	if len(queries) > searchHistorySize {
+		queries = queries[:searchHistorySize]
+	}
+
+	return queries
+}
+
+// recordSearch adds query to the front of the history cookie, removing any
+// earlier copy of it and dropping the oldest entries to stay within
+// searchHistorySize and the cookie size limit. Clients that send "DNT: 1"
+// are not tracked.
+func recordSearch(w http.ResponseWriter, r *http.Request, query string) {
+	if searchHistorySize == 0 || query == "" || r.Header.Get("DNT") == "1" {
+		return
+	}
+
+	queries := []string{query}
+	for _, q := range searchHistory(r) {
+		if q != query && len(queries) < searchHistorySize {
+			queries = append(queries, q)
+		}
+	}
+
+	for len(queries) > 0 {
+		data, err := json.Marshal(queries)
+		if err != nil {
+			return
+		}
+
+		value := base64.RawURLEncoding.EncodeToString(data)
+		value += "." + signHistory(value)
+
+		if len(value) <= maxHistoryCookieSize {
+			setHistoryCookie(w, r, value, int(historyCookieMaxAge.Seconds()))
+			return
+		}
+
+		queries = queries[:len(queries)-1]
+	}
+}
+
+func setHistoryCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     historyCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearHistoryHandler expires the history cookie and sends the browser back
+// to the index page.
+func clearHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	setHistoryCookie(w, r, "", -1)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
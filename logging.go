@@ -0,0 +1,472 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	defaultLogMaxSize    = 5
+	defaultLogMaxBackups = 10
+	defaultLogMaxAge     = 14
+
+	defaultLogSampleInitial    = 100
+	defaultLogSampleThereafter = 100
+	logSampleTick              = time.Second
+)
+
+type logLevel int32
+
+const (
+	levelDebug logLevel = iota - 1
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+func parseLogLevel(s string) (logLevel, error) {
+	for level, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return level, nil
+		}
+	}
+
+	return levelInfo, fmt.Errorf("unknown log level '%s'", s)
+}
+
+var currentLogLevel atomic.Int32
+
+func setLogLevel(l logLevel) {
+	currentLogLevel.Store(int32(l))
+}
+
+func getLogLevel() logLevel {
+	return logLevel(currentLogLevel.Load())
+}
+
+// logSampler caps the volume of repeated log lines. Within each tick, the
+// first initial lines from a call site are logged and after that only every
+// thereafter-th one. Call sites are identified by level and format string.
+type logSampler struct {
+	mu         sync.Mutex
+	initial    int
+	thereafter int
+	resetAt    time.Time
+	counts     map[logSamplerKey]int
+}
+
+type logSamplerKey struct {
+	level  logLevel
+	format string
+}
+
+func newLogSampler(initial, thereafter int) *logSampler {
+	return &logSampler{
+		initial:    initial,
+		thereafter: thereafter,
+		counts:     make(map[logSamplerKey]int),
+	}
+}
+
+func (s *logSampler) allow(l logLevel, format string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if !now.Before(s.resetAt) {
+		s.resetAt = now.Add(logSampleTick)
+		s.counts = make(map[logSamplerKey]int)
+	}
+
+	key := logSamplerKey{level: l, format: format}
+	s.counts[key]++
+
+	n := s.counts[key]
+	if n <= s.initial {
+		return true
+	}
+
+	return s.thereafter > 0 && (n-s.initial)%s.thereafter == 0
+}
+
+// sampler is nil unless sampling is enabled with LOG_SAMPLE_INITIAL or
+// LOG_SAMPLE_THEREAFTER.
+var sampler *logSampler
+
+func logAt(l logLevel, name, format string, v ...any) {
+	if l < getLogLevel() {
+		return
+	}
+
+	// Errors are never sampled.
+	if sampler != nil && l < levelError && !sampler.allow(l, format) {
+		return
+	}
+
+	msg := fmt.Sprintf(format, v...)
+
+	var stack string
+	if logStacktrace && l >= levelError {
+		stack = stackTrace(3)
+	}
+
+	if logFormat == "json" {
+		var caller string
+		if logCaller {
+			caller = callerLocation(3)
+		}
+
+		logJSON(l, name, msg, caller, stack)
+
+		return
+	}
+
+	if stack != "" {
+		msg += "\n" + stack
+	}
+
+	if name != "" {
+		msg = "[" + name + "] " + msg
+	}
+
+	log.Output(3, strings.ToUpper(l.String())+" "+msg)
+}
+
+// logCaller and logStacktrace are set from LOG_CALLER and LOG_STACKTRACE.
+// The first adds the file and line of the logging call to every line, the
+// second adds a stack trace to error logs.
+var (
+	logCaller     bool
+	logStacktrace bool
+)
+
+// callerLocation returns the file:line of a frame on the stack, in the same
+// short form as log.Lshortfile. skip counts frames as in runtime.Caller.
+func callerLocation(skip int) string {
+	_, file, line, ok := runtime.Caller(skip)
+	if !ok {
+		return "???:0"
+	}
+
+	return filepath.Base(file) + ":" + strconv.Itoa(line)
+}
+
+// stackTrace formats the calling goroutine's stack. skip counts frames as in
+// runtime.Caller, so the logging helpers themselves can be left out.
+func stackTrace(skip int) string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(skip+1, pcs)
+
+	var b strings.Builder
+
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+
+		if !more {
+			break
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// logFormat is "console" for the standard log prefix followed by the level
+// and message, or "json" for one JSON object per line, as set by LOG_FORMAT.
+var logFormat = "console"
+
+var jsonLogger = log.New(os.Stderr, "", 0)
+
+// logJSON writes msg as a JSON object. A trailing group of key=value pairs
+// in parentheses, such as "(correlation_id=abc)" from logFields, becomes
+// top-level fields so collectors can index them.
+func logJSON(l logLevel, name, msg, caller, stack string) {
+	entry := map[string]string{
+		"time":  time.Now().Format(time.RFC3339Nano),
+		"level": l.String(),
+	}
+
+	if name != "" {
+		entry["logger"] = name
+	}
+
+	if caller != "" {
+		entry["caller"] = caller
+	}
+
+	if stack != "" {
+		entry["stacktrace"] = stack
+	}
+
+	msg, fields := splitLogFields(msg)
+	for key, value := range fields {
+		if _, ok := entry[key]; !ok {
+			entry[key] = value
+		}
+	}
+
+	entry["msg"] = msg
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Unable to encode log entry: %v", err)
+		return
+	}
+
+	jsonLogger.Print(string(data))
+}
+
+func splitLogFields(msg string) (string, map[string]string) {
+	if !strings.HasSuffix(msg, ")") {
+		return msg, nil
+	}
+
+	i := strings.LastIndex(msg, " (")
+	if i < 0 {
+		return msg, nil
+	}
+
+	fields := make(map[string]string)
+
+	rest := msg[i+2 : len(msg)-1]
+	for rest = strings.TrimLeft(rest, " "); rest != ""; rest = strings.TrimLeft(rest, " ") {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok || key == "" || strings.Contains(key, " ") {
+			return msg, nil
+		}
+
+		if strings.HasPrefix(value, `"`) {
+			quoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return msg, nil
+			}
+
+			rest = value[len(quoted):]
+			value, _ = strconv.Unquote(quoted)
+		} else {
+			value, rest, _ = strings.Cut(value, " ")
+		}
+
+		fields[key] = value
+	}
+
+	return msg[:i], fields
+}
+
+func logDebugf(format string, v ...any) {
+	logAt(levelDebug, "", format, v...)
+}
+
+func logInfof(format string, v ...any) {
+	logAt(levelInfo, "", format, v...)
+}
+
+func logWarnf(format string, v ...any) {
+	logAt(levelWarn, "", format, v...)
+}
+
+func logErrorf(format string, v ...any) {
+	logAt(levelError, "", format, v...)
+}
+
+// namedLogger logs like the package-level helpers but tags each line with
+// the subsystem it came from, as "[name]" in console output and a logger
+// field in JSON output.
+type namedLogger struct {
+	name string
+}
+
+func newNamedLogger(name string) namedLogger {
+	return namedLogger{name: name}
+}
+
+var (
+	httpLog  = newNamedLogger("http")
+	wikiLog  = newNamedLogger("wiki")
+	cacheLog = newNamedLogger("cache")
+)
+
+func (n namedLogger) Debugf(format string, v ...any) {
+	logAt(levelDebug, n.name, format, v...)
+}
+
+func (n namedLogger) Infof(format string, v ...any) {
+	logAt(levelInfo, n.name, format, v...)
+}
+
+func (n namedLogger) Warnf(format string, v ...any) {
+	logAt(levelWarn, n.name, format, v...)
+}
+
+func (n namedLogger) Errorf(format string, v ...any) {
+	logAt(levelError, n.name, format, v...)
+}
+
+func (n namedLogger) Logf(l logLevel, format string, v ...any) {
+	logAt(l, n.name, format, v...)
+}
+
+// errorLogLevel is the level a failed request is logged at: client errors
+// are expected and only warrant a warning.
+func errorLogLevel(err error) logLevel {
+	if errorStatus(err) < http.StatusInternalServerError {
+		return levelWarn
+	}
+
+	return levelError
+}
+
+// setupLogging sends log output to the console and, when LOG_FILE is set, to
+// a size-rotated log file as well.
+func setupLogging() {
+	level := levelInfo
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		var err error
+
+		level, err = parseLogLevel(v)
+		if err != nil {
+			log.Fatalf("Invalid LOG_LEVEL: %v", err)
+		}
+	}
+
+	setLogLevel(level)
+
+	logCaller = envBool("LOG_CALLER", false)
+	if logCaller {
+		log.SetFlags(log.Flags() | log.Lshortfile)
+	}
+
+	logStacktrace = envBool("LOG_STACKTRACE", false)
+
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "":
+	case "console", "json":
+		logFormat = format
+	default:
+		log.Fatalf("Invalid LOG_FORMAT '%s', expected 'console' or 'json'", format)
+	}
+
+	switch format := os.Getenv("ACCESS_LOG_FORMAT"); format {
+	case "":
+	case "default", "combined":
+		accessLogFormat = format
+	default:
+		log.Fatalf("Invalid ACCESS_LOG_FORMAT '%s', expected 'default' or 'combined'", format)
+	}
+
+	_, initialSet := os.LookupEnv("LOG_SAMPLE_INITIAL")
+	_, thereafterSet := os.LookupEnv("LOG_SAMPLE_THEREAFTER")
+	if initialSet || thereafterSet {
+		sampler = newLogSampler(
+			envInt("LOG_SAMPLE_INITIAL", defaultLogSampleInitial),
+			envInt("LOG_SAMPLE_THEREAFTER", defaultLogSampleThereafter),
+		)
+	}
+
+	filename := os.Getenv("LOG_FILE")
+	if filename == "" {
+		return
+	}
+
+	logFile = &lumberjack.Logger{
+		Filename:   filename,
+		MaxSize:    envInt("LOG_MAX_SIZE", defaultLogMaxSize),
+		MaxBackups: envInt("LOG_MAX_BACKUPS", defaultLogMaxBackups),
+		MaxAge:     envInt("LOG_MAX_AGE", defaultLogMaxAge),
+		Compress:   envBool("LOG_COMPRESS", true),
+	}
+
+	log.SetOutput(io.MultiWriter(os.Stderr, logFile))
+	accessLogger.SetOutput(log.Writer())
+	jsonLogger.SetOutput(log.Writer())
+}
+
+// logFile is the rotated log file, or nil when LOG_FILE is not set.
+var logFile *lumberjack.Logger
+
+// syncLogging flushes stderr and closes the log file so nothing written
+// during shutdown is lost. Syncing stderr fails with EINVAL or ENOTTY when it
+// is a pipe or terminal, which is harmless and ignored.
+func syncLogging() {
+	err := os.Stderr.Sync()
+	if err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOTTY) {
+		fmt.Fprintf(os.Stderr, "Unable to sync log output: %v\n", err)
+	}
+
+	if logFile == nil {
+		return
+	}
+
+	err = logFile.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to close log file: %v\n", err)
+	}
+}
+
+type logLevelPayload struct {
+	Level string `json:"level"`
+}
+
+const maxLogLevelBodySize = 1 << 10
+
+// logLevelHandler reports the current log level on GET and changes it on PUT
+// with a body such as {"level":"debug"}.
+func logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, logLevelPayload{Level: getLogLevel().String()})
+	case http.MethodPut:
+		var payload logLevelPayload
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxLogLevelBodySize)
+
+		err := json.NewDecoder(r.Body).Decode(&payload)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+			return
+		}
+
+		level, err := parseLogLevel(payload.Level)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+			return
+		}
+
+		setLogLevel(level)
+		logInfof("Log level changed to '%s'", level)
+
+		writeJSON(w, http.StatusOK, logLevelPayload{Level: level.String()})
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeJSON(w, http.StatusMethodNotAllowed, APIError{
+			Error: "only GET and PUT are supported",
+		})
+	}
+}
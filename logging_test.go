@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorLogLevel(t *testing.T) {
+	tests := []struct {
+		err  error
+		want logLevel
+	}{
+		{newStatusError(http.StatusBadRequest, errors.New("invalid page number")), levelWarn},
+		{newStatusError(http.StatusForbidden, errors.New("blocked query")), levelWarn},
+		{newStatusError(http.StatusTooManyRequests, errors.New("throttled")), levelWarn},
+		{newStatusError(http.StatusBadGateway, errors.New("bad upstream")), levelError},
+		{context.DeadlineExceeded, levelError},
+		{errors.New("boom"), levelError},
+	}
+
+	for _, tt := range tests {
+		if got := errorLogLevel(tt.err); got != tt.want {
+			t.Errorf("errorLogLevel(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestLogSampler(t *testing.T) {
+	s := newLogSampler(3, 5)
+
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		if s.allow(levelInfo, "Searching Wikipedia in language '%s'") {
+			allowed++
+		}
+	}
+
+	// The first 3, then the 5th and 10th and 15th after them.
+	if allowed != 6 {
+		t.Errorf("allowed %d of 20 lines, want 6", allowed)
+	}
+
+	if !s.allow(levelInfo, "another call site") {
+		t.Error("a different call site was sampled with the first one")
+	}
+
+	s.resetAt = time.Now().Add(-time.Second)
+	if !s.allow(levelInfo, "Searching Wikipedia in language '%s'") {
+		t.Error("counts were not reset after the tick")
+	}
+}
+
+// captureLog sends the standard logger's output to the returned buffer for
+// the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(io.Discard) })
+
+	return &buf
+}
+
+func TestLogAtSampling(t *testing.T) {
+	buf := captureLog(t)
+
+	sampler = newLogSampler(2, 0)
+	t.Cleanup(func() { sampler = nil })
+
+	for i := 0; i < 10; i++ {
+		logInfof("sampled info line %d", i)
+		logErrorf("unsampled error line %d", i)
+	}
+
+	if got := strings.Count(buf.String(), "sampled info line"); got != 2 {
+		t.Errorf("logged %d info lines, want 2", got)
+	}
+
+	if got := strings.Count(buf.String(), "unsampled error line"); got != 10 {
+		t.Errorf("logged %d error lines, want all 10", got)
+	}
+
+	buf.Reset()
+	sampler = nil
+
+	for i := 0; i < 10; i++ {
+		logInfof("sampled info line %d", i)
+	}
+
+	if got := strings.Count(buf.String(), "sampled info line"); got != 10 {
+		t.Errorf("logged %d info lines with sampling disabled, want 10", got)
+	}
+}
+
+func TestLogCaller(t *testing.T) {
+	flags := log.Flags()
+	t.Cleanup(func() {
+		log.SetFlags(flags)
+		logCaller = false
+		logStacktrace = false
+		logFormat = "console"
+		jsonLogger.SetOutput(io.Discard)
+	})
+
+	logCaller = true
+
+	loggers := map[string]func(){
+		"logInfof":      func() { logInfof("caller test") },
+		"httpLog.Warnf": func() { httpLog.Warnf("caller test") },
+		"httpLog.Logf":  func() { httpLog.Logf(levelError, "caller test") },
+	}
+
+	t.Run("console", func(t *testing.T) {
+		buf := captureLog(t)
+		log.SetFlags(log.Lshortfile)
+
+		for name, logf := range loggers {
+			buf.Reset()
+			logf()
+
+			if !strings.HasPrefix(buf.String(), "logging_test.go:") {
+				t.Errorf("%s: %q, want the test file as caller", name, buf.String())
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		jsonLogger.SetOutput(&buf)
+		logFormat = "json"
+		logStacktrace = true
+
+		for name, logf := range loggers {
+			buf.Reset()
+			logf()
+
+			var entry map[string]string
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			if !strings.HasPrefix(entry["caller"], "logging_test.go:") {
+				t.Errorf("%s: caller = %q, want the test file", name, entry["caller"])
+			}
+
+			stack, hasStack := entry["stacktrace"]
+			if wantStack := entry["level"] == "error"; hasStack != wantStack {
+				t.Errorf("%s: stacktrace present %t at level %s", name, hasStack, entry["level"])
+			}
+
+			if hasStack && !strings.Contains(stack, "TestLogCaller") {
+				t.Errorf("%s: stacktrace doesn't include the test:\n%s", name, stack)
+			}
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var buf bytes.Buffer
+		jsonLogger.SetOutput(&buf)
+		logFormat = "json"
+		logCaller = false
+
+		logInfof("caller test")
+
+		if strings.Contains(buf.String(), `"caller"`) {
+			t.Errorf("%q has a caller with LOG_CALLER off", buf.String())
+		}
+	})
+}
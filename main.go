@@ -2,68 +2,339 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"math"
+	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const defaultLanguage = "en"
+
+var wikipediaLanguages = map[string]bool{
+	"en": true,
+	"fr": true,
+	"de": true,
+	"es": true,
+	"it": true,
+	"ja": true,
+	"pt": true,
+	"ru": true,
+	"zh": true,
+}
+
+const defaultSort = "relevance"
+
+var sortOptions = map[string]string{
+	"relevance":             "Relevance",
+	"just_match":            "Exact match",
+	"last_edit_desc":        "Most recently edited",
+	"last_edit_asc":         "Least recently edited",
+	"create_timestamp_desc": "Newest",
+	"create_timestamp_asc":  "Oldest",
+	"incoming_links_desc":   "Most linked",
+	"incoming_links_asc":    "Least linked",
+	"random":                "Random",
+}
+
+const defaultNamespace = "0"
+
+// wikipediaNamespaces are the standard MediaWiki namespaces that can be
+// searched, such as 0 for articles, 10 for templates and 14 for categories.
+var wikipediaNamespaces = map[int]bool{
+	0:  true,
+	1:  true,
+	2:  true,
+	3:  true,
+	4:  true,
+	5:  true,
+	6:  true,
+	7:  true,
+	8:  true,
+	9:  true,
+	10: true,
+	11: true,
+	12: true,
+	13: true,
+	14: true,
+	15: true,
+}
+
+// parseNamespaces validates a comma-separated list of namespace numbers and
+// returns it in canonical form, without duplicates or whitespace.
+func parseNamespaces(s string) (string, error) {
+	if strings.TrimSpace(s) == "" {
+		return defaultNamespace, nil
+	}
+
+	seen := make(map[int]bool)
+
+	var namespaces []string
+
+	for _, part := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || !wikipediaNamespaces[n] {
+			return "", fmt.Errorf("invalid namespace '%s'", strings.TrimSpace(part))
+		}
+
+		if seen[n] {
+			continue
+		}
+
+		seen[n] = true
+		namespaces = append(namespaces, strconv.Itoa(n))
+	}
+
+	return strings.Join(namespaces, ","), nil
+}
+
+var searchCache *SearchCache
+
+var (
+	searchTimeout       = defaultSearchTimeout
+	slowSearchThreshold = defaultSlowSearchThreshold
+	maxPage             = defaultMaxPage
+	maxOffset           = defaultMaxOffset
+)
+
+const (
+	defaultSearchTimeout       = 10 * time.Second
+	defaultSlowSearchThreshold = 2 * time.Second
+	defaultMaxPage             = 500
+	defaultMaxOffset           = 10000
+	defaultPageSize            = 20
+	minPageSize                = 1
+	maxPageSize                = 50
+	maxSearchRetries           = 3
+	retryBaseDelay             = 200 * time.Millisecond
+	shutdownTimeout            = 15 * time.Second
+	defaultHTTPClientTimeout   = 30 * time.Second
+	defaultReadHeaderTimeout   = 5 * time.Second
+	defaultReadTimeout         = 15 * time.Second
+	defaultWriteTimeout        = 30 * time.Second
+	defaultIdleTimeout         = 120 * time.Second
+	defaultRequestTimeout      = 20 * time.Second
 )
 
-var tpl *template.Template
+type application struct {
+	wiki *WikipediaClient
+}
 
-var HTTPClient = http.Client{
-	Timeout: 30 * time.Second,
+type WikipediaAPIError struct {
+	Code string `json:"code"`
+	Info string `json:"info"`
+}
+
+func (e *WikipediaAPIError) Error() string {
+	return fmt.Sprintf("Wikipedia API error '%s': %s", e.Code, e.Info)
 }
 
 type WikipediaSearchResponse struct {
-	BatchComplete string `json:"batchcomplete"`
-	Continue      struct {
+	BatchComplete string             `json:"batchcomplete"`
+	Error         *WikipediaAPIError `json:"error"`
+	Warnings      map[string]struct {
+		Text string `json:"*"`
+	} `json:"warnings"`
+	Continue struct {
 		Sroffset int    `json:"sroffset"`
 		Continue string `json:"continue"`
 	} `json:"continue"`
 	Query struct {
 		SearchInfo struct {
-			TotalHits int `json:"totalhits"`
+			TotalHits  int    `json:"totalhits"`
+			Suggestion string `json:"suggestion"`
 		} `json:"searchinfo"`
-		Search []struct {
-			Ns        int       `json:"ns"`
-			Title     string    `json:"title"`
-			PageID    int       `json:"pageid"`
-			Size      int       `json:"size"`
-			WordCount int       `json:"wordcount"`
-			Snippet   string    `json:"snippet"`
-			Timestamp time.Time `json:"timestamp"`
-		} `json:"search"`
+		Search []WikipediaSearchResult `json:"search"`
 	} `json:"query"`
 }
 
+type WikipediaSearchResult struct {
+	Ns        int       `json:"ns"`
+	Title     string    `json:"title"`
+	PageID    int       `json:"pageid"`
+	Size      int       `json:"size"`
+	WordCount int       `json:"wordcount"`
+	Snippet   string    `json:"snippet"`
+	Timestamp time.Time `json:"timestamp"`
+	Thumbnail string    `json:"-"`
+	FullURL   string    `json:"-"`
+}
+
+// URL returns the canonical article URL, falling back to one built from the
+// page ID if it couldn't be looked up.
+func (r WikipediaSearchResult) URL(lang string) string {
+	if r.FullURL != "" {
+		return r.FullURL
+	}
+
+	return articleURL(lang, r.PageID)
+}
+
 type Search struct {
 	Query      string
+	Lang       string
+	Sort       string
+	Namespace  string
+	Highlight  bool
+	NewTab     bool
+	Limit      int
+	Suggestion string
+	TotalHits  int
 	TotalPages int
 	NextPage   int
-	Results    *WikipediaSearchResponse
+	// ContinueOffset is the sroffset continuation token for the next page,
+	// or zero if Wikipedia did not return one.
+	ContinueOffset int
+	// CorrectedFrom is the query the visitor typed when it found nothing
+	// and autocorrect replaced it with Wikipedia's suggestion.
+	CorrectedFrom string
+	// Stale is set when Wikipedia failed and the results are an expired
+	// cache entry.
+	Stale bool
+	// RecentSearches lists the visitor's previous queries on the index page.
+	RecentSearches []string
+	// Duration is how long the upstream search took, or zero when the
+	// results came from the cache.
+	Duration time.Duration
+	Results  *WikipediaSearchResponse
+}
+
+func (s *Search) SortLabel() string {
+	return sortOptions[s.Sort]
 }
 
 func (s *Search) IsLastPage() bool {
-	return s.NextPage >= s.TotalPages
+	return s.CurrentPage() >= s.TotalPages
 }
 
 func (s *Search) CurrentPage() int {
-	if s.NextPage == 1 {
-		return s.NextPage
+	if s.NextPage <= 1 {
+		return 1
 	}
 
 	return s.NextPage - 1
 }
 
+// RangeStart and RangeEnd are the 1-based positions of the first and last
+// results shown on the current page.
+func (s *Search) RangeStart() int {
+	if s.TotalHits == 0 {
+		return 0
+	}
+
+	return (s.CurrentPage()-1)*s.Limit + 1
+}
+
+func (s *Search) RangeEnd() int {
+	end := s.CurrentPage() * s.Limit
+	if end > s.TotalHits {
+		return s.TotalHits
+	}
+
+	return end
+}
+
+// PageURL returns the link to the given page of the current search. The
+// continuation offset is included when linking to the next page.
+func (s *Search) PageURL(page int) string {
+	params := s.params()
+	params.Set("page", strconv.Itoa(page))
+
+	if page == s.NextPage && s.ContinueOffset > 0 {
+		params.Set("sroffset", strconv.Itoa(s.ContinueOffset))
+	}
+
+	return "/search?" + params.Encode()
+}
+
+// QueryURL returns the link to the first page of a search for q with the
+// current search's settings, for spelling suggestions and corrections.
+func (s *Search) QueryURL(q string) string {
+	params := s.params()
+	params.Set("q", q)
+
+	return "/search?" + params.Encode()
+}
+
+// FeedURL returns the link to the RSS feed of the current search.
+func (s *Search) FeedURL() string {
+	return "/search.rss?" + s.params().Encode()
+}
+
+func (s *Search) params() url.Values {
+	params := url.Values{}
+	params.Set("q", s.Query)
+	params.Set("lang", s.Lang)
+	params.Set("sort", s.Sort)
+	params.Set("namespace", s.Namespace)
+	params.Set("limit", strconv.Itoa(s.Limit))
+
+	if !s.Highlight {
+		params.Set("highlight", "false")
+	}
+
+	return params
+}
+
+// Snippet renders a result snippet for the template, with the search term
+// highlights kept or stripped depending on the highlight parameter.
+func (s *Search) Snippet(snippet string) template.HTML {
+	if !s.Highlight {
+		return template.HTML(template.HTMLEscapeString(
+			truncateSnippetText(stripHighlights(snippet), snippetMaxLength),
+		))
+	}
+
+	return sanitizeSnippet(snippet, snippetMaxLength)
+}
+
+const pageWindowSize = 3
+
+// PageWindow returns the page numbers to link to around the current page, up
+// to pageWindowSize on either side and clamped to the available pages.
+func (s *Search) PageWindow() []int {
+	if s.TotalPages < 1 {
+		return nil
+	}
+
+	first := s.CurrentPage() - pageWindowSize
+	if first < 1 {
+		first = 1
+	}
+
+	last := s.CurrentPage() + pageWindowSize
+	if last > s.TotalPages {
+		last = s.TotalPages
+	}
+
+	pages := make([]int, 0, last-first+1)
+	for page := first; page <= last; page++ {
+		pages = append(pages, page)
+	}
+
+	return pages
+}
+
 func (s *Search) PreviousPage() int {
+	if s.CurrentPage() <= 1 {
+		return 1
+	}
+
 	return s.CurrentPage() - 1
 }
 
@@ -72,150 +343,851 @@ type handlerWithError func(w http.ResponseWriter, r *http.Request) error
 func (fn handlerWithError) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	err := fn(w, r)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		correlationID := CorrelationIDFromCtx(r.Context())
+
+		httpLog.Logf(errorLogLevel(err), "%s (%s)", redact(err.Error()), logFields(r.Context()))
+		setRetryAfter(w, err)
+		renderError(w, err, correlationID)
+
 		return
 	}
 }
 
-func indexHandler(w http.ResponseWriter, r *http.Request) error {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return nil
+type StatusError interface {
+	Status() int
+	error
+}
+
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func (e *httpError) Unwrap() error {
+	return e.err
+}
+
+func (e *httpError) Status() int {
+	return e.status
+}
+
+func newStatusError(status int, err error) error {
+	return &httpError{status: status, err: err}
+}
+
+func errorStatus(err error) int {
+	var statusErr StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status()
 	}
 
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+
+	return http.StatusInternalServerError
+}
+
+// The index page has no dynamic data other than the visitor's search history,
+// so it is rendered once and served with a content-derived ETag to visitors
+// without one.
+var (
+	indexOnce sync.Once
+	indexPage []byte
+	indexETag string
+	indexErr  error
+)
+
+func renderIndex() {
 	buf := &bytes.Buffer{}
-	err := tpl.Execute(buf, nil)
+
+	t, err := pageTemplate("index")
 	if err != nil {
-		return err
+		indexErr = err
+		return
 	}
 
-	_, err = buf.WriteTo(w)
+	indexErr = t.Execute(buf, nil)
+	if indexErr != nil {
+		return
+	}
 
-	return err
+	sum := sha256.Sum256(buf.Bytes())
+
+	indexPage = buf.Bytes()
+	indexETag = `"` + hex.EncodeToString(sum[:16]) + `"`
 }
 
-func searchWikipedia(
-	searchQuery string,
-	pageSize, resultsOffset int,
-) (*WikipediaSearchResponse, error) {
-	resp, err := HTTPClient.Get(
-		fmt.Sprintf(
-			"https://en.wikipedia.org/w/api.php?action=query&list=search&prop=info&inprop=url&utf8=&format=json&origin=*&srlimit=%d&srsearch=%s&sroffset=%d",
-			pageSize,
-			url.QueryEscape(searchQuery),
-			resultsOffset,
-		),
-	)
-	if err != nil {
-		return nil, err
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
 	}
 
-	defer resp.Body.Close()
+	return false
+}
 
-	if resp.StatusCode != http.StatusOK {
-		respData, _ := httputil.DumpResponse(resp, true)
+func indexHandler(w http.ResponseWriter, r *http.Request) error {
+	if history := searchHistory(r); len(history) > 0 || isDevelopment {
+		w.Header().Set("Cache-Control", "private, no-cache")
 
-		return nil, fmt.Errorf(
-			"non 200 OK response from Wikipedia API: %s",
-			string(respData),
-		)
+		return renderTemplate(w, r, "index", http.StatusOK, &Search{
+			RecentSearches: history,
+		})
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	indexOnce.Do(renderIndex)
+	if indexErr != nil {
+		return indexErr
 	}
 
-	var searchResponse WikipediaSearchResponse
+	w.Header().Set("ETag", indexETag)
 
-	err = json.Unmarshal(body, &searchResponse)
-	if err != nil {
-		return nil, err
+	if etagMatches(r.Header.Get("If-None-Match"), indexETag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(indexPage)))
+
+	if r.Method == http.MethodHead {
+		return nil
 	}
 
-	return &searchResponse, nil
+	_, err := w.Write(indexPage)
+
+	return err
 }
 
-func searchHandler(w http.ResponseWriter, r *http.Request) error {
+func (app *application) runSearch(r *http.Request) (*Search, error) {
 	u, err := url.Parse(r.URL.String())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	params := u.Query()
-	searchQuery := params.Get("q")
+	searchQuery := strings.TrimSpace(params.Get("q"))
 	pageNum := params.Get("page")
 	if pageNum == "" {
 		pageNum = "1"
 	}
 
+	lang := params.Get("lang")
+	if !wikipediaLanguages[lang] {
+		lang = defaultLanguage
+	}
+
+	wikiLog.Infof("Searching Wikipedia in language '%s'", lang)
+
+	sort := params.Get("sort")
+	if _, ok := sortOptions[sort]; !ok {
+		sort = defaultSort
+	}
+
+	err = validateSearchQuery(searchQuery)
+	if err != nil {
+		return nil, newStatusError(http.StatusBadRequest, err)
+	}
+
+	if searchQuery != "" {
+		r = r.WithContext(addLogFields(r.Context(), "search_query", redact(searchQuery)))
+	}
+
+	if queryBlocked(searchQuery) {
+		logWarnf(
+			"Blocked search query '%s' (%s)",
+			redact(searchQuery),
+			logFields(r.Context()),
+		)
+
+		return nil, newStatusError(http.StatusForbidden, errQueryBlocked)
+	}
+
+	namespace, err := parseNamespaces(params.Get("namespace"))
+	if err != nil {
+		return nil, newStatusError(http.StatusBadRequest, err)
+	}
+
+	highlight := true
+	if v := params.Get("highlight"); v != "" {
+		highlight, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, newStatusError(
+				http.StatusBadRequest,
+				fmt.Errorf("invalid highlight value '%s'", v),
+			)
+		}
+	}
+
+	autocorrect := false
+	if v := params.Get("autocorrect"); v != "" {
+		autocorrect, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, newStatusError(
+				http.StatusBadRequest,
+				fmt.Errorf("invalid autocorrect value '%s'", v),
+			)
+		}
+	}
+
 	nextPage, err := strconv.Atoi(pageNum)
 	if err != nil {
-		return err
+		return nil, newStatusError(
+			http.StatusBadRequest,
+			fmt.Errorf("invalid page number '%s': %w", pageNum, err),
+		)
+	}
+
+	if nextPage < 1 {
+		nextPage = 1
+	}
+
+	if nextPage > maxPage {
+		return nil, newStatusError(
+			http.StatusBadRequest,
+			fmt.Errorf("page number %d exceeds the maximum of %d", nextPage, maxPage),
+		)
+	}
+
+	// Fall back to the page size the visitor chose last time.
+	limit := params.Get("limit")
+	if limit == "" {
+		limit = preferredPageSize(r)
+	}
+
+	pageSize, err := strconv.Atoi(limit)
+	if err != nil || pageSize < minPageSize || pageSize > maxPageSize {
+		pageSize = defaultPageSize
 	}
 
-	pageSize := 20
+	if searchQuery == "" {
+		logDebugf(
+			"Skipping Wikipedia search for empty query (%s)",
+			logFields(r.Context()),
+		)
+
+		return &Search{
+			Lang:      lang,
+			Sort:      sort,
+			Namespace: namespace,
+			Highlight: highlight,
+			Limit:     pageSize,
+			Results:   &WikipediaSearchResponse{},
+		}, nil
+	}
 
+	// Prefer the continuation offset Wikipedia returned for the previous page
+	// over recomputing it from the page number.
 	resultsOffset := (nextPage - 1) * pageSize
+	if sroffset, err := strconv.Atoi(params.Get("sroffset")); err == nil && sroffset >= 0 {
+		resultsOffset = sroffset
+	}
+
+	// Wikipedia refuses to page past a fixed number of results, so don't
+	// bother sending requests that are bound to fail.
+	if resultsOffset+pageSize > maxOffset {
+		return nil, newStatusError(
+			http.StatusBadRequest,
+			fmt.Errorf(
+				"results offset %d exceeds the maximum of %d, only the first %d pages of %d results are available",
+				resultsOffset,
+				maxOffset,
+				maxOffset/pageSize,
+				pageSize,
+			),
+		)
+	}
 
-	searchResponse, err := searchWikipedia(searchQuery, pageSize, resultsOffset)
+	// The body of a HEAD response is discarded, so there's no point asking
+	// Wikipedia for results once the parameters have been validated.
+	if r.Method == http.MethodHead {
+		return &Search{
+			Query:     searchQuery,
+			Lang:      lang,
+			Sort:      sort,
+			Namespace: namespace,
+			Highlight: highlight,
+			Limit:     pageSize,
+			NextPage:  nextPage + 1,
+			Results:   &WikipediaSearchResponse{},
+		}, nil
+	}
+
+	cacheKey := searchCacheKey{
+		Query:         searchQuery,
+		PageSize:      pageSize,
+		ResultsOffset: resultsOffset,
+		Lang:          lang,
+		Sort:          sort,
+		Namespace:     namespace,
+	}
+
+	searchesTotal.Inc()
+
+	var stale bool
+
+	searchResponse, elapsed, err := app.cachedSearch(r.Context(), cacheKey)
 	if err != nil {
-		return err
+		searchResponse, stale = staleSearch(cacheKey, err)
+		if !stale {
+			return nil, err
+		}
+
+		cacheLog.Warnf(
+			"Serving stale results for search query '%s' after upstream error: %s (%s)",
+			redact(searchQuery),
+			redact(err.Error()),
+			logFields(r.Context()),
+		)
+	}
+
+	// Retry at most once with Wikipedia's suggestion, and only from the
+	// first page, so a suggestion that also finds nothing can't lead to
+	// another retry.
+	var correctedFrom string
+
+	suggestion := searchResponse.Query.SearchInfo.Suggestion
+	if autocorrect &&
+		resultsOffset == 0 &&
+		searchResponse.Query.SearchInfo.TotalHits == 0 &&
+		suggestion != "" &&
+		!strings.EqualFold(suggestion, searchQuery) &&
+		!queryBlocked(suggestion) {
+		logInfof(
+			"No results for search query '%s', retrying with suggestion '%s' (%s)",
+			redact(searchQuery),
+			redact(suggestion),
+			logFields(r.Context()),
+		)
+
+		cacheKey.Query = suggestion
+
+		corrected, correctedElapsed, err := app.cachedSearch(r.Context(), cacheKey)
+		if err != nil {
+			return nil, err
+		}
+
+		correctedFrom = searchQuery
+		searchQuery = suggestion
+		searchResponse = corrected
+		elapsed += correctedElapsed
 	}
 
 	totalHits := searchResponse.Query.SearchInfo.TotalHits
 
+	totalPages := int(math.Ceil(float64(totalHits) / float64(pageSize)))
+	if lastPage := maxOffset / pageSize; totalPages > lastPage {
+		totalPages = lastPage
+	}
+
 	search := &Search{
-		Query:      searchQuery,
-		Results:    searchResponse,
-		TotalPages: int(math.Ceil(float64(totalHits) / float64(pageSize))),
-		NextPage:   nextPage + 1,
+		Query:          searchQuery,
+		Lang:           lang,
+		Sort:           sort,
+		Namespace:      namespace,
+		Highlight:      highlight,
+		Limit:          pageSize,
+		Suggestion:     searchResponse.Query.SearchInfo.Suggestion,
+		CorrectedFrom:  correctedFrom,
+		Stale:          stale,
+		Results:        searchResponse,
+		TotalHits:      totalHits,
+		TotalPages:     totalPages,
+		Duration:       elapsed,
+		NextPage:       nextPage + 1,
+		ContinueOffset: searchResponse.Continue.Sroffset,
 	}
 
-	buf := &bytes.Buffer{}
-	err = tpl.Execute(buf, search)
+	return search, nil
+}
+
+// cachedSearch returns the cached response for key, or fetches it from
+// Wikipedia on a cache miss. The duration is zero for cache hits.
+func (app *application) cachedSearch(
+	ctx context.Context,
+	key searchCacheKey,
+) (*WikipediaSearchResponse, time.Duration, error) {
+	if searchResponse, ok := searchCache.Get(key); ok {
+		cacheLog.Infof("Cache hit for search query '%s'", redact(key.Query))
+		cacheRequestsTotal.WithLabelValues("hit").Inc()
+
+		return searchResponse, 0, nil
+	}
+
+	cacheLog.Infof("Cache miss for search query '%s'", redact(key.Query))
+	cacheRequestsTotal.WithLabelValues("miss").Inc()
+
+	return app.coalescedSearch(ctx, key)
+}
+
+// staleSearch returns an expired cached response for key when err is an
+// upstream failure, so visitors get outdated results rather than an error
+// page. Client errors are never masked.
+func staleSearch(key searchCacheKey, err error) (*WikipediaSearchResponse, bool) {
+	if errorStatus(err) < http.StatusInternalServerError {
+		return nil, false
+	}
+
+	searchResponse, ok := searchCache.GetStale(key)
+	if ok {
+		cacheRequestsTotal.WithLabelValues("stale").Inc()
+	}
+
+	return searchResponse, ok
+}
+
+// fetchSearch queries Wikipedia for key, fills in page details and caches
+// the response. It returns how long the upstream search took.
+func (app *application) fetchSearch(
+	ctx context.Context,
+	key searchCacheKey,
+) (*WikipediaSearchResponse, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, searchTimeout)
+	defer cancel()
+
+	err := acquireSearchSlot(ctx)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
+	defer releaseSearchSlot()
 
-	_, err = buf.WriteTo(w)
+	start := time.Now()
+
+	searchResponse, err := app.wiki.Search(
+		ctx,
+		key.Query,
+		key.PageSize,
+		key.ResultsOffset,
+		key.Lang,
+		key.Sort,
+		key.Namespace,
+	)
+	if err != nil {
+		var statusErr StatusError
+		if errors.As(err, &statusErr) {
+			return nil, 0, err
+		}
+
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, 0, newStatusError(
+				http.StatusGatewayTimeout,
+				fmt.Errorf("search timed out after %s: %w", time.Since(start), err),
+			)
+		}
+
+		if errors.Is(err, context.Canceled) {
+			return nil, 0, err
+		}
+
+		return nil, 0, newStatusError(http.StatusBadGateway, err)
+	}
+
+	elapsed := time.Since(start)
+	if elapsed > slowSearchThreshold {
+		wikiLog.Warnf(
+			"Slow Wikipedia search for query '%s' took %s (%s)",
+			redact(key.Query),
+			elapsed,
+			logFields(ctx),
+		)
+	} else {
+		wikiLog.Infof("Wikipedia search for query '%s' took %s", redact(key.Query), elapsed)
+	}
+
+	if dropped := dedupeResults(searchResponse); dropped > 0 {
+		wikiLog.Warnf(
+			"Dropped %d duplicate results for search query '%s' (%s)",
+			dropped,
+			redact(key.Query),
+			logFields(ctx),
+		)
+	}
+
+	err = app.wiki.addPageInfo(ctx, key.Lang, searchResponse)
+	if err != nil {
+		wikiLog.Warnf(
+			"Unable to fetch result page info, not caching results: %v (%s)",
+			err,
+			logFields(ctx),
+		)
+
+		return searchResponse, elapsed, nil
+	}
+
+	searchCache.Set(key, searchResponse)
+
+	return searchResponse, elapsed, nil
+}
+
+// openResultsInNewTab controls whether links to Wikipedia articles on the
+// results page open in a new tab.
+var openResultsInNewTab = true
+
+// debugHeaders controls whether search responses carry the
+// X-Search-Duration-Ms and X-Total-Hits headers.
+var debugHeaders = true
+
+func setSearchDebugHeaders(w http.ResponseWriter, search *Search) {
+	if !debugHeaders {
+		return
+	}
+
+	w.Header().Set("X-Search-Duration-Ms", strconv.FormatInt(search.Duration.Milliseconds(), 10))
+	w.Header().Set("X-Total-Hits", strconv.Itoa(search.TotalHits))
+}
+
+func (app *application) searchHandler(w http.ResponseWriter, r *http.Request) error {
+	search, err := app.runSearch(r)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if r.Method == http.MethodHead {
+		return writeHead(w, "text/html; charset=utf-8")
+	}
+
+	// Wikipedia answers an offset past the last result with an empty page,
+	// so send the visitor to the last page that has results instead.
+	if search.TotalPages > 0 && search.CurrentPage() > search.TotalPages {
+		http.Redirect(w, r, search.PageURL(search.TotalPages), http.StatusFound)
+		return nil
+	}
+
+	search.NewTab = openResultsInNewTab
+
+	// Remember an explicit, valid limit. Invalid values fall back to the
+	// default page size and shouldn't replace the visitor's choice.
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit == search.Limit {
+		rememberPageSize(w, r, search.Limit)
+	}
+
+	recordSearch(w, r, search.Query)
+
+	// Only count the first page so paging through results doesn't inflate
+	// a query's popularity.
+	if search.CurrentPage() == 1 {
+		queryStats.Record(redact(search.Query), time.Now())
+	}
+
+	setSearchDebugHeaders(w, search)
+
+	return renderTemplate(w, r, "index", http.StatusOK, search)
 }
 
-func htmlSafe(str string) template.HTML {
-	return template.HTML(str)
+// writeHead answers a HEAD request with the headers a GET would have sent,
+// minus those that depend on the body.
+func writeHead(w http.ResponseWriter, contentType string) error {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+
+	return nil
 }
 
 var err error
 
 func init() {
-	tpl, err = template.New("index.html").Funcs(template.FuncMap{
-		"htmlSafe": htmlSafe,
-	}).ParseFiles("index.html")
+	err = loadAssetFingerprints(assetsDir)
+	if err != nil {
+		log.Fatal("Unable to fingerprint static assets")
+	}
+
+	templates, err = parseTemplates(templatesDir)
 	if err != nil {
 		log.Fatal("Unable to initialize HTML templates")
 	}
+
+	for _, name := range []string{"index", "article", "notfound", "error", "maintenance"} {
+		if templates[name] == nil {
+			log.Fatalf("Missing %s template", name)
+		}
+	}
 }
 
 func main() {
-	fs := http.FileServer(http.Dir("assets"))
+	setupLogging()
+	defer syncLogging()
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		log.Fatalf("Unable to initialize tracing: %v", err)
+	}
+
+	fs := http.FileServer(http.Dir(assetsDir))
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "3000"
 	}
 
+	// HOST restricts the server to a single interface, such as 127.0.0.1.
+	// Leaving it empty listens on all of them.
+	addr := net.JoinHostPort(os.Getenv("HOST"), port)
+
+	searchCache = NewSearchCache(
+		envDuration("CACHE_TTL", defaultCacheTTL),
+		envDuration("CACHE_STALE_TTL", defaultCacheStaleTTL),
+		defaultCacheSize,
+	)
+
+	searchTimeout = envDuration("SEARCH_TIMEOUT", defaultSearchTimeout)
+	slowSearchThreshold = time.Duration(
+		envInt("SLOW_SEARCH_MS", int(defaultSlowSearchThreshold/time.Millisecond)),
+	) * time.Millisecond
+	maxPage = envInt("MAX_PAGE", defaultMaxPage)
+	maxOffset = envInt("MAX_OFFSET", defaultMaxOffset)
+	snippetMaxLength = envInt("SNIPPET_MAX", 0)
+	debugHeaders = envBool("DEBUG_HEADERS", true)
+	openResultsInNewTab = envBool("RESULTS_NEW_TAB", true)
+	maintenanceRetryAfter = envDuration("MAINTENANCE_RETRY_AFTER", defaultMaintenanceRetryAfter)
+	setMaintenanceMode(envBool("MAINTENANCE", false))
+	streamTemplates = envBool("STREAM_TEMPLATES", false)
+
+	wikiAPIBase := defaultWikiAPIBase
+	if base := os.Getenv("WIKI_API_BASE"); base != "" {
+		_, err = url.Parse(strings.ReplaceAll(base, "{lang}", defaultLanguage))
+		if err != nil {
+			log.Fatalf("Invalid WIKI_API_BASE '%s': %v", base, err)
+		}
+
+		wikiAPIBase = base
+	}
+
+	// The client timeout is a backstop; handlers set their own, usually
+	// shorter, deadlines on the request context and those win.
+	httpClientTimeout := envDuration("HTTP_CLIENT_TIMEOUT", defaultHTTPClientTimeout)
+	if httpClientTimeout < searchTimeout {
+		logWarnf(
+			"HTTP_CLIENT_TIMEOUT (%s) is shorter than SEARCH_TIMEOUT (%s), searches will be cut off early",
+			httpClientTimeout,
+			searchTimeout,
+		)
+	}
+
+	app := &application{
+		wiki: NewWikipediaClient(
+			&http.Client{
+				Timeout:   httpClientTimeout,
+				Transport: wikipediaTransportFromEnv(),
+			},
+			wikiAPIBase,
+		),
+	}
+
+	if csp, ok := os.LookupEnv("CONTENT_SECURITY_POLICY"); ok {
+		contentSecurityPolicy = csp
+	}
+
+	if origins := os.Getenv("CORS_ALLOWED_ORIGINS"); origins != "" {
+		corsAllowedOrigins = parseOrigins(origins)
+	}
+
+	isDevelopment = os.Getenv("APP_ENV") == "development"
+
+	if patterns, ok := os.LookupEnv("LOG_REDACT_PATTERNS"); ok {
+		redactPatterns = parseRedactPatterns(patterns)
+	}
+
+	queryBlocklist = loadBlocklist(
+		os.Getenv("QUERY_BLOCKLIST"),
+		os.Getenv("QUERY_BLOCKLIST_FILE"),
+	)
+
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		trustedProxies = parseTrustedProxies(proxies)
+	}
+
+	if secret := os.Getenv("VISITOR_ID_SECRET"); secret != "" {
+		visitorSecret = []byte(secret)
+	} else {
+		logWarnf("VISITOR_ID_SECRET is not set, visitor IDs will reset on restart")
+	}
+
+	historySecret = visitorSecret
+	if secret := os.Getenv("SEARCH_HISTORY_SECRET"); secret != "" {
+		historySecret = []byte(secret)
+	}
+
+	searchHistorySize = envInt("SEARCH_HISTORY_SIZE", defaultSearchHistorySize)
+
+	if contact := os.Getenv("WIKI_USER_AGENT_CONTACT"); contact != "" {
+		userAgent = buildUserAgent(contact)
+	}
+
+	statsTopN = envInt("STATS_TOP_N", defaultStatsTopN)
+	queryStats = NewQueryStats(
+		statsWindow,
+		statsBucketWidth,
+		envInt("STATS_MAX_QUERIES", defaultStatsMaxQueries),
+	)
+
+	searchSlots = newSearchSlots(envInt("MAX_CONCURRENT_SEARCHES", defaultMaxConcurrentSearches))
+
+	if rps := envFloat("PER_IP_RPS", 0); rps > 0 {
+		clientLimiters = NewClientLimiters(
+			rps,
+			envInt("PER_IP_BURST", 0),
+			envInt("PER_IP_MAX_CLIENTS", defaultPerIPMaxClients),
+			perIPIdleTimeout,
+		)
+	}
+
+	wikipediaLimiter = newWikipediaLimiter(
+		envFloat("WIKI_RATE_LIMIT", defaultWikiRateLimit),
+	)
+
+	breakerTimeout = envDuration("CIRCUIT_BREAKER_TIMEOUT", defaultBreakerTimeout)
+	wikipediaBreaker = newWikipediaBreaker(
+		envInt("CIRCUIT_BREAKER_FAILURES", defaultBreakerFailures),
+		breakerTimeout,
+	)
+
 	mux := http.NewServeMux()
-	mux.Handle("/assets/", http.StripPrefix("/assets/", fs))
-	mux.Handle("/search", handlerWithError(searchHandler))
-	mux.Handle("/", handlerWithError(indexHandler))
+	mux.Handle("/assets/", http.StripPrefix("/assets/", assetCacheMiddleware(fs)))
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/version", versionHandler)
+	mux.HandleFunc("/readyz", app.readyHandler)
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/stats", statsHandler)
+
+	if envBool("LOG_LEVEL_ENDPOINT", false) {
+		mux.HandleFunc("/loglevel", logLevelHandler)
+	}
+	if envBool("MAINTENANCE_ENDPOINT", false) {
+		mux.HandleFunc("/maintenance", maintenanceHandler)
+	}
+	mux.Handle("/search", methodGuard(
+		negotiateContent(
+			handlerWithError(app.searchHandler),
+			apiHandlerWithError(app.apiSearchHandler),
+		),
+		http.MethodGet,
+		http.MethodHead,
+	))
+	mux.Handle("/search/stream", methodGuard(apiHandlerWithError(app.searchStreamHandler), http.MethodGet))
+	mux.Handle("/search.rss", methodGuard(handlerWithError(app.searchRSSHandler), http.MethodGet, http.MethodHead))
+	mux.Handle("/article", methodGuard(handlerWithError(app.articleHandler), http.MethodGet, http.MethodHead))
+	mux.Handle(
+		"/api/search",
+		corsMiddleware(apiHandlerWithError(app.apiSearchHandler)),
+	)
+	mux.Handle(
+		"/suggest",
+		corsMiddleware(apiHandlerWithError(app.suggestHandler)),
+	)
+	mux.Handle("/history/clear", methodGuard(http.HandlerFunc(clearHistoryHandler), http.MethodPost))
+	mux.HandleFunc("/favicon.ico", faviconHandler)
+	mux.Handle("/", methodGuard(
+		exactPath("/", handlerWithError(indexHandler), handlerWithError(notFoundHandler)),
+		http.MethodGet,
+		http.MethodHead,
+	))
 
-	log.Printf("Starting Wikipedia App Server on port '%s'", port)
+	requestTimeout := envDuration("REQUEST_TIMEOUT", defaultRequestTimeout)
+	if requestTimeout > 0 && requestTimeout < searchTimeout {
+		logWarnf(
+			"REQUEST_TIMEOUT (%s) is shorter than SEARCH_TIMEOUT (%s), searches will be cut off early",
+			requestTimeout,
+			searchTimeout,
+		)
+	}
+
+	handler := timeoutMiddleware(mux, requestTimeout)
+	handler = gzipMiddleware(handler)
+	handler = recoverMiddleware(handler)
+	handler = throttleMiddleware(handler)
+	handler = maintenanceMiddleware(handler)
+	handler = securityHeadersMiddleware(handler)
+	handler = requestLogger(handler)
+
+	writeTimeout := envDuration("SERVER_WRITE_TIMEOUT", defaultWriteTimeout)
+	if writeTimeout > 0 && writeTimeout <= searchTimeout {
+		logWarnf(
+			"SERVER_WRITE_TIMEOUT (%s) is not longer than SEARCH_TIMEOUT (%s), slow searches will be cut off",
+			writeTimeout,
+			searchTimeout,
+		)
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: envDuration("SERVER_READ_HEADER_TIMEOUT", defaultReadHeaderTimeout),
+		ReadTimeout:       envDuration("SERVER_READ_TIMEOUT", defaultReadTimeout),
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       envDuration("SERVER_IDLE_TIMEOUT", defaultIdleTimeout),
+	}
+
+	certFile, keyFile := os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY")
+	if (certFile == "") != (keyFile == "") {
+		log.Fatal("TLS_CERT and TLS_KEY must be set together")
+	}
 
-	log.Fatal(http.ListenAndServe(":"+port, mux))
+	if domains := os.Getenv("TLS_AUTOCERT_DOMAIN"); domains != "" {
+		if certFile != "" {
+			log.Fatal("TLS_AUTOCERT_DOMAIN cannot be combined with TLS_CERT and TLS_KEY")
+		}
+
+		server.TLSConfig = autocertTLSConfig(domains, os.Getenv("TLS_AUTOCERT_CACHE_DIR"))
+	}
+
+	ctx, stop := signal.NotifyContext(
+		context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
+	defer stop()
+
+	logBuildInfo()
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	go func() {
+		var err error
+
+		switch {
+		case server.TLSConfig != nil:
+			logInfof("Starting Wikipedia App Server on '%s' with Let's Encrypt certificates", ln.Addr())
+			err = server.ServeTLS(ln, "", "")
+		case certFile != "":
+			logInfof("Starting Wikipedia App Server on '%s' with TLS", ln.Addr())
+			err = server.ServeTLS(ln, certFile, keyFile)
+		default:
+			logInfof("Starting Wikipedia App Server on '%s'", ln.Addr())
+			err = server.Serve(ln)
+		}
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	logInfof("Shutting down server, waiting up to %s for in-flight requests", shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		logWarnf("Server shutdown was forced: %v", err)
+	} else {
+		logInfof("Server shut down cleanly")
+	}
+
+	err = shutdownTracing(shutdownCtx)
+	if err != nil {
+		logWarnf("Unable to flush traces: %v", err)
+	}
 }
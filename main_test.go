@@ -0,0 +1,677 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	accessLogger.SetOutput(io.Discard)
+	jsonLogger.SetOutput(io.Discard)
+
+	os.Exit(m.Run())
+}
+
+// fakeTotalHits lists the queries the fake Wikipedia API knows about and how
+// many results each has. Any other query gets a 500.
+var fakeTotalHits = map[string]int{
+	"golang":      45,
+	"C++ & C#":    3,
+	"rock & roll": 3,
+	"Zürich 東京":   3,
+
+	`intitle:"exact phrase"`: 3,
+	"hastemplate:Infobox":    3,
+}
+
+// fakeWikipedia mimics the parts of api.php the app uses: list=search for
+// the queries in fakeTotalHits and prop=pageimages|info lookups. It counts
+// the requests and searches it receives and records the last search.
+type fakeWikipedia struct {
+	*httptest.Server
+
+	// anyQueryHits, when set, is the number of results returned for
+	// queries missing from fakeTotalHits instead of failing them.
+	anyQueryHits int
+
+	// failPageInfo makes prop=pageimages|info lookups fail.
+	failPageInfo bool
+
+	// maxlagSearches is the number of searches refused for replication lag
+	// before the rest are answered.
+	maxlagSearches int
+
+	// searchGate, when set, holds searches until it is closed. Searches
+	// whose request is cancelled while held are counted in cancelled.
+	searchGate chan struct{}
+
+	requests   atomic.Int64
+	searches   atomic.Int64
+	cancelled  atomic.Int64
+	lastSearch atomic.Value
+}
+
+func newFakeWikipedia(t testing.TB) *fakeWikipedia {
+	t.Helper()
+
+	fake := &fakeWikipedia{}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+	t.Cleanup(fake.Close)
+
+	return fake
+}
+
+func (f *fakeWikipedia) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests.Add(1)
+
+	params := r.URL.Query()
+
+	switch {
+	case params.Get("list") == "search":
+		f.searches.Add(1)
+		f.lastSearch.Store(r.Clone(r.Context()))
+
+		if f.searches.Load() <= int64(f.maxlagSearches) {
+			w.Header().Set("Retry-After", "0")
+			w.Header().Set(databaseLagHeader, "7")
+			http.Error(w, "replication lag", http.StatusServiceUnavailable)
+
+			return
+		}
+
+		if f.searchGate != nil {
+			select {
+			case <-f.searchGate:
+			case <-r.Context().Done():
+				f.cancelled.Add(1)
+				return
+			}
+		}
+
+		query := params.Get("srsearch")
+
+		totalHits, ok := fakeTotalHits[query]
+		if !ok && f.anyQueryHits > 0 {
+			totalHits, ok = f.anyQueryHits, true
+		}
+
+		if !ok {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+
+		limit, _ := strconv.Atoi(params.Get("srlimit"))
+		offset, _ := strconv.Atoi(params.Get("sroffset"))
+
+		writeTestJSON(w, fakeSearchResponse(query, totalHits, limit, offset))
+	case params.Get("prop") == "pageimages|info" && f.failPageInfo:
+		http.Error(w, "page info unavailable", http.StatusServiceUnavailable)
+	case params.Get("prop") == "pageimages|info":
+		var infoResponse WikipediaPageInfoResponse
+
+		infoResponse.Query.Pages = make(map[string]struct {
+			PageID    int    `json:"pageid"`
+			FullURL   string `json:"fullurl"`
+			Thumbnail struct {
+				Source string `json:"source"`
+			} `json:"thumbnail"`
+		})
+
+		for _, id := range strings.Split(params.Get("pageids"), "|") {
+			pageID, _ := strconv.Atoi(id)
+
+			page := infoResponse.Query.Pages[id]
+			page.PageID = pageID
+			page.FullURL = "https://en.wikipedia.org/wiki/Page_" + id
+			infoResponse.Query.Pages[id] = page
+		}
+
+		writeTestJSON(w, infoResponse)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func fakeSearchResponse(query string, totalHits, limit, offset int) *WikipediaSearchResponse {
+	resp := &WikipediaSearchResponse{BatchComplete: ""}
+	resp.Query.SearchInfo.TotalHits = totalHits
+	resp.Query.Search = []WikipediaSearchResult{}
+
+	for i := offset; i < totalHits && i < offset+limit; i++ {
+		resp.Query.Search = append(resp.Query.Search, WikipediaSearchResult{
+			Title:     fmt.Sprintf("%s result %d", query, i+1),
+			PageID:    1000 + i,
+			WordCount: 100 + i,
+			Snippet:   fmt.Sprintf(`about <span class="searchmatch">%s</span> number %d`, html.EscapeString(query), i+1),
+			Timestamp: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		})
+	}
+
+	if offset+limit < totalHits {
+		resp.Continue.Sroffset = offset + limit
+		resp.Continue.Continue = "-||"
+	}
+
+	return resp
+}
+
+func writeTestJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// resetSearchState resets the package state that searches share, so tests
+// don't see each other's cache entries or tripped breakers.
+func resetSearchState() {
+	searchCache = NewSearchCache(defaultCacheTTL, defaultCacheStaleTTL, defaultCacheSize)
+	wikipediaBreaker = newWikipediaBreaker(defaultBreakerFailures, defaultBreakerTimeout)
+	wikipediaLimiter = newWikipediaLimiter(1e6)
+}
+
+// newTestApp points a fresh application at fake.
+func newTestApp(t testing.TB, fake *fakeWikipedia) *application {
+	t.Helper()
+
+	resetSearchState()
+
+	return &application{
+		wiki: NewWikipediaClient(fake.Client(), fake.URL),
+	}
+}
+
+func serveSearch(app *application, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handlerWithError(app.searchHandler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+	return rec
+}
+
+func TestSearchHandlerRendersResults(t *testing.T) {
+	app := newTestApp(t, newFakeWikipedia(t))
+
+	rec := serveSearch(app, "/search?q=golang&page=2")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	body := rec.Body.String()
+
+	for i := 21; i <= 40; i++ {
+		title := fmt.Sprintf("golang result %d<", i)
+		if !strings.Contains(body, title) {
+			t.Errorf("body is missing title %q", title)
+		}
+	}
+
+	if strings.Contains(body, "golang result 41<") || strings.Contains(body, "golang result 20<") {
+		t.Error("body contains results from another page")
+	}
+
+	if !strings.Contains(body, "<strong>21&ndash;40</strong>") {
+		t.Error("body is missing the 21–40 result range")
+	}
+}
+
+func TestSearchHandlerPagination(t *testing.T) {
+	app := newTestApp(t, newFakeWikipedia(t))
+
+	tests := []struct {
+		target     string
+		page       int
+		totalPages int
+		rangeStart int
+		rangeEnd   int
+		lastPage   bool
+	}{
+		{"/search?q=golang", 1, 3, 1, 20, false},
+		{"/search?q=golang&page=2", 2, 3, 21, 40, false},
+		{"/search?q=golang&page=3", 3, 3, 41, 45, true},
+		{"/search?q=golang&limit=10&page=5", 5, 5, 41, 45, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			search, err := app.runSearch(httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := search.CurrentPage(); got != tt.page {
+				t.Errorf("CurrentPage() = %d, want %d", got, tt.page)
+			}
+
+			if search.TotalPages != tt.totalPages {
+				t.Errorf("TotalPages = %d, want %d", search.TotalPages, tt.totalPages)
+			}
+
+			if got := search.RangeStart(); got != tt.rangeStart {
+				t.Errorf("RangeStart() = %d, want %d", got, tt.rangeStart)
+			}
+
+			if got := search.RangeEnd(); got != tt.rangeEnd {
+				t.Errorf("RangeEnd() = %d, want %d", got, tt.rangeEnd)
+			}
+
+			if got := search.IsLastPage(); got != tt.lastPage {
+				t.Errorf("IsLastPage() = %t, want %t", got, tt.lastPage)
+			}
+		})
+	}
+}
+
+func TestSearchHandlerPageLinks(t *testing.T) {
+	app := newTestApp(t, newFakeWikipedia(t))
+
+	search, err := app.runSearch(httptest.NewRequest(http.MethodGet, "/search?q=golang&page=2", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := serveSearch(app, "/search?q=golang&page=2").Body.String()
+
+	for _, page := range []int{search.PreviousPage(), search.NextPage, search.TotalPages} {
+		link := search.PageURL(page)
+
+		if !strings.Contains(link, "page="+strconv.Itoa(page)) {
+			t.Errorf("PageURL(%d) = %q, want a page=%d param", page, link, page)
+		}
+
+		if !strings.Contains(body, `href="`+html.EscapeString(link)+`"`) {
+			t.Errorf("body is missing a link to %q", link)
+		}
+	}
+}
+
+func TestSearchHandlerRedirectsPastLastPage(t *testing.T) {
+	app := newTestApp(t, newFakeWikipedia(t))
+
+	rec := serveSearch(app, "/search?q=golang&page=50&limit=10")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusFound)
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := location.Query()
+	if location.Path != "/search" || params.Get("q") != "golang" || params.Get("page") != "5" || params.Get("limit") != "10" {
+		t.Fatalf("Location = %q, want the last page of the same search", location)
+	}
+
+	rec = serveSearch(app, location.String())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status = %d, want %d", location, rec.Code, http.StatusOK)
+	}
+
+	if !strings.Contains(rec.Body.String(), "golang result 45<") {
+		t.Error("last page is missing the last result")
+	}
+}
+
+func TestSearchResultsUseFullURL(t *testing.T) {
+	app := newTestApp(t, newFakeWikipedia(t))
+
+	search, err := app.runSearch(httptest.NewRequest(http.MethodGet, "/search?q=golang", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := serveSearch(app, "/search?q=golang").Body.String()
+	api := newAPISearchResponse(search)
+
+	for i, result := range search.Results.Query.Search {
+		want := "https://en.wikipedia.org/wiki/Page_" + strconv.Itoa(result.PageID)
+		if result.FullURL != want {
+			t.Errorf("result %d: FullURL = %q, want %q", i, result.FullURL, want)
+		}
+
+		if !strings.Contains(body, `href="`+want+`"`) {
+			t.Errorf("result %d: page is missing a link to %q", i, want)
+		}
+
+		if api.Results[i].URL != want {
+			t.Errorf("result %d: API url = %q, want %q", i, api.Results[i].URL, want)
+		}
+	}
+
+	if strings.Contains(body, "?curid=") {
+		t.Error("page links to an article by page ID despite having its URL")
+	}
+
+	result := WikipediaSearchResult{PageID: 42}
+	if got, want := result.URL("de"), "https://de.wikipedia.org?curid=42"; got != want {
+		t.Errorf("URL() without FullURL = %q, want %q", got, want)
+	}
+}
+
+func TestSearchHandlerUpstreamError(t *testing.T) {
+	app := newTestApp(t, newFakeWikipedia(t))
+
+	rec := serveSearch(app, "/search?q=unknown")
+	if rec.Code < 500 {
+		t.Fatalf("status = %d, want a 5xx", rec.Code)
+	}
+
+	if strings.Contains(rec.Body.String(), "boom") {
+		t.Error("error page leaks the upstream response body")
+	}
+}
+
+func TestSearchPageInfoFailureNotCached(t *testing.T) {
+	for _, failPageInfo := range []bool{false, true} {
+		t.Run(fmt.Sprintf("failPageInfo=%t", failPageInfo), func(t *testing.T) {
+			fake := newFakeWikipedia(t)
+			fake.failPageInfo = failPageInfo
+
+			app := newTestApp(t, fake)
+
+			for i := 0; i < 2; i++ {
+				search, err := app.runSearch(httptest.NewRequest(http.MethodGet, "/search?q=golang", nil))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got := search.Results.Query.Search[0].FullURL != ""; got == failPageInfo {
+					t.Errorf("FullURL set = %t, want %t", got, !failPageInfo)
+				}
+			}
+
+			want := int64(1)
+			if failPageInfo {
+				want = 2
+			}
+
+			if got := fake.searches.Load(); got != want {
+				t.Errorf("upstream searches = %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestSearchHandlerEmptyQuery(t *testing.T) {
+	fake := newFakeWikipedia(t)
+	app := newTestApp(t, fake)
+
+	for _, q := range []string{"", "q=", "q=+++", "q=%09%0A", "q=%E3%80%80"} {
+		rec := serveSearch(app, "/search?"+q)
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET /search?%s: status = %d, want %d", q, rec.Code, http.StatusOK)
+		}
+
+		text := strings.Join(strings.Fields(rec.Body.String()), " ")
+		if !strings.Contains(text, "Please enter a search term.") {
+			t.Errorf("GET /search?%s: page doesn't ask for a search term", q)
+		}
+	}
+
+	if got := fake.requests.Load(); got != 0 {
+		t.Errorf("upstream requests = %d, want 0", got)
+	}
+
+	rec := serveSearch(app, "/search?q=++golang%09")
+	if !strings.Contains(rec.Body.String(), "golang result 1<") {
+		t.Error("GET /search?q=++golang%09: page is missing the results")
+	}
+
+	req := fake.lastSearch.Load().(*http.Request)
+	if got := req.URL.Query().Get("srsearch"); got != "golang" {
+		t.Errorf("srsearch = %q, want the trimmed query", got)
+	}
+}
+
+func TestSearchRejectsOtherMethods(t *testing.T) {
+	fake := newFakeWikipedia(t)
+	app := newTestApp(t, fake)
+
+	handler := methodGuard(handlerWithError(app.searchHandler), http.MethodGet, http.MethodHead)
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, "/search?q=golang", nil))
+
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s /search: status = %d, want %d", method, rec.Code, http.StatusMethodNotAllowed)
+		}
+
+		if got := rec.Header().Get("Allow"); got != "GET, HEAD" {
+			t.Errorf("%s /search: Allow = %q, want %q", method, got, "GET, HEAD")
+		}
+	}
+
+	if got := fake.requests.Load(); got != 0 {
+		t.Errorf("upstream requests = %d, want 0", got)
+	}
+}
+
+func TestSearchHead(t *testing.T) {
+	fake := newFakeWikipedia(t)
+	app := newTestApp(t, fake)
+
+	tests := []struct {
+		target      string
+		handler     http.Handler
+		contentType string
+	}{
+		{"/search?q=golang", handlerWithError(app.searchHandler), "text/html; charset=utf-8"},
+		{"/api/search?q=golang", apiHandlerWithError(app.apiSearchHandler), "application/json"},
+		{"/search.rss?q=golang", handlerWithError(app.searchRSSHandler), "application/rss+xml; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, tt.target, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("HEAD %s: status = %d, want %d", tt.target, rec.Code, http.StatusOK)
+		}
+
+		if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("HEAD %s: Content-Type = %q, want %q", tt.target, got, tt.contentType)
+		}
+
+		if rec.Body.Len() != 0 {
+			t.Errorf("HEAD %s: response has a %d byte body", tt.target, rec.Body.Len())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handlerWithError(app.searchHandler).ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/search?q=golang&page=abc", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("HEAD with an invalid page: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	if got := fake.requests.Load(); got != 0 {
+		t.Errorf("upstream requests = %d, want 0", got)
+	}
+}
+
+func TestIndexHandlerNotModified(t *testing.T) {
+	handler := handlerWithError(indexHandler)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" || rec.Body.Len() == 0 {
+		t.Fatalf("GET / = %d with ETag %q and %d bytes, want a 200 with an ETag and a body", rec.Code, etag, rec.Body.Len())
+	}
+
+	tests := []struct {
+		ifNoneMatch string
+		status      int
+	}{
+		{etag, http.StatusNotModified},
+		{"W/" + etag, http.StatusNotModified},
+		{`"other", ` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"other"`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("If-None-Match", tt.ifNoneMatch)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("If-None-Match %s: status = %d, want %d", tt.ifNoneMatch, rec.Code, tt.status)
+		}
+
+		if tt.status == http.StatusNotModified && rec.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: 304 has a %d byte body", tt.ifNoneMatch, rec.Body.Len())
+		}
+
+		if got := rec.Header().Get("ETag"); got != etag {
+			t.Errorf("If-None-Match %s: ETag = %q, want %q", tt.ifNoneMatch, got, etag)
+		}
+	}
+}
+
+func FuzzSearchHandler(f *testing.F) {
+	for _, seed := range []string{
+		"q=golang",
+		"q=golang&page=2&limit=10",
+		"q=日本語の検索&lang=ja",
+		"q=%F0%9F%94%8D+emoji&sort=last_edit_desc",
+		"q=" + strings.Repeat("a", 10000),
+		"q=" + strings.Repeat("ü", 5000) + "&page=3",
+		"q=golang&page=-5",
+		"q=golang&page=0",
+		"q=golang&page=99999999999999999999",
+		"q=golang&page=abc",
+		"q=golang&namespace=abc",
+		"q=golang&namespace=0,,-1,999999999999",
+		"q=golang&sroffset=-1",
+		"q=golang&limit=-20&highlight=maybe",
+		"q=intitle:\"unterminated",
+		"q=%zz&page=%",
+		"q=&page=2",
+		"",
+	} {
+		f.Add(seed)
+	}
+
+	fake := newFakeWikipedia(f)
+	fake.anyQueryHits = 45
+
+	app := newTestApp(f, fake)
+
+	f.Fuzz(func(t *testing.T, rawQuery string) {
+		req := httptest.NewRequest(http.MethodGet, "/search", nil)
+		req.URL.RawQuery = rawQuery
+
+		rec := httptest.NewRecorder()
+		handlerWithError(app.searchHandler).ServeHTTP(rec, req)
+
+		switch {
+		case rec.Code == http.StatusOK,
+			rec.Code == http.StatusFound,
+			rec.Code == http.StatusBadRequest,
+			rec.Code == http.StatusForbidden,
+			rec.Code >= 500 && rec.Code <= 599:
+		default:
+			t.Errorf("GET /search?%s: unexpected status %d", rawQuery, rec.Code)
+		}
+	})
+}
+
+func BenchmarkCurrentPage(b *testing.B) {
+	search := benchmarkSearch()
+	search.NextPage = 8
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_ = search.CurrentPage()
+	}
+}
+
+func BenchmarkPreviousPage(b *testing.B) {
+	search := benchmarkSearch()
+	search.NextPage = 8
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_ = search.PreviousPage()
+	}
+}
+
+func BenchmarkPageWindow(b *testing.B) {
+	search := benchmarkSearch()
+	search.NextPage = 8
+	search.TotalPages = 25
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_ = search.PageWindow()
+	}
+}
+
+func TestSearchPageValidation(t *testing.T) {
+	fake := newFakeWikipedia(t)
+	app := newTestApp(t, fake)
+
+	tests := []struct {
+		page       string
+		status     int
+		wantPage   int
+		wantOffset string
+	}{
+		{"0", http.StatusOK, 1, "0"},
+		{"-5", http.StatusOK, 1, "0"},
+		{"2", http.StatusOK, 2, "20"},
+		{"abc", http.StatusBadRequest, 0, ""},
+		{"2.5", http.StatusBadRequest, 0, ""},
+		{strconv.Itoa(maxPage + 1), http.StatusBadRequest, 0, ""},
+		{"99999999999999999999", http.StatusBadRequest, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run("page="+tt.page, func(t *testing.T) {
+			search, err := app.runSearch(httptest.NewRequest(http.MethodGet, "/search?q=golang&page="+tt.page, nil))
+			if tt.status != http.StatusOK {
+				if err == nil || errorStatus(err) != tt.status {
+					t.Fatalf("runSearch() error = %v, want status %d", err, tt.status)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := search.CurrentPage(); got != tt.wantPage {
+				t.Errorf("CurrentPage() = %d, want %d", got, tt.wantPage)
+			}
+
+			if got := search.PreviousPage(); got < 1 {
+				t.Errorf("PreviousPage() = %d, want at least 1", got)
+			}
+
+			req := fake.lastSearch.Load().(*http.Request)
+			if got := req.URL.Query().Get("sroffset"); got != tt.wantOffset {
+				t.Errorf("sroffset = %s, want %s", got, tt.wantOffset)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaintenanceRetryAfter = 5 * time.Minute
+	maxMaintenanceBodySize       = 1 << 10
+)
+
+var (
+	maintenanceMode       atomic.Bool
+	maintenanceRetryAfter = defaultMaintenanceRetryAfter
+)
+
+// maintenanceExemptPaths keep working in maintenance mode: the liveness
+// check, build version, metrics, the maintenance toggle and the log level
+// endpoint. The assets the maintenance page needs are exempt through the
+// /assets/ prefix check in maintenanceMiddleware instead. /readyz is
+// deliberately not exempt so load balancers take the instance out of
+// rotation.
+var maintenanceExemptPaths = map[string]bool{
+	"/healthz":     true,
+	"/version":     true,
+	"/metrics":     true,
+	"/maintenance": true,
+	"/loglevel":    true,
+}
+
+func setMaintenanceMode(enabled bool) {
+	if maintenanceMode.Swap(enabled) == enabled {
+		return
+	}
+
+	if enabled {
+		logWarnf("Entered maintenance mode")
+	} else {
+		logInfof("Exited maintenance mode")
+	}
+}
+
+func maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !maintenanceMode.Load() ||
+			maintenanceExemptPaths[r.URL.Path] ||
+			strings.HasPrefix(r.URL.Path, "/assets/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set(
+			"Retry-After",
+			strconv.Itoa(int(maintenanceRetryAfter.Seconds())),
+		)
+
+		if strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/suggest" {
+			writeJSON(w, http.StatusServiceUnavailable, APIError{
+				Error:         "the service is down for maintenance",
+				CorrelationID: CorrelationIDFromCtx(r.Context()),
+			})
+
+			return
+		}
+
+		err := renderTemplate(w, r, "maintenance", http.StatusServiceUnavailable, nil)
+		if err != nil {
+			logErrorf("Unable to render maintenance page: %v", err)
+			http.Error(w, "Down for maintenance", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+type maintenancePayload struct {
+	Enabled bool `json:"enabled"`
+}
+
+// maintenanceHandler reports whether maintenance mode is on with GET and
+// toggles it with PUT and a body such as {"enabled":true}.
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, maintenancePayload{Enabled: maintenanceMode.Load()})
+	case http.MethodPut:
+		var payload maintenancePayload
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxMaintenanceBodySize)
+
+		err := json.NewDecoder(r.Body).Decode(&payload)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+			return
+		}
+
+		setMaintenanceMode(payload.Enabled)
+
+		writeJSON(w, http.StatusOK, payload)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeJSON(w, http.StatusMethodNotAllowed, APIError{
+			Error: "only GET and PUT are supported",
+		})
+	}
+}
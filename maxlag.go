@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wikipediaMaxlag is the replication lag, in seconds, above which Wikipedia
+// should refuse our searches rather than add to the load on lagging
+// database replicas.
+const wikipediaMaxlag = 5
+
+const (
+	defaultMaxlagRetryAfter = 5 * time.Second
+	maxlagErrorCode         = "maxlag"
+	databaseLagHeader       = "X-Database-Lag"
+)
+
+type maxlagError struct {
+	lag        string
+	retryAfter time.Duration
+}
+
+func (e *maxlagError) Error() string {
+	return fmt.Sprintf(
+		"Wikipedia database replicas are lagging by %s seconds, retry after %s",
+		e.lag,
+		e.retryAfter,
+	)
+}
+
+func (e *maxlagError) Status() int {
+	return http.StatusServiceUnavailable
+}
+
+func (e *maxlagError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// newMaxlagError builds a maxlagError from the headers Wikipedia sends with
+// a maxlag refusal, falling back to a default wait when Retry-After is
+// missing or malformed.
+func newMaxlagError(resp *http.Response) *maxlagError {
+	retryAfter := defaultMaxlagRetryAfter
+	if seconds, err := strconv.Atoi(strings.TrimSpace(resp.Header.Get("Retry-After"))); err == nil && seconds >= 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+
+	lag := resp.Header.Get(databaseLagHeader)
+	if lag == "" {
+		lag = "?"
+	}
+
+	return &maxlagError{lag: lag, retryAfter: retryAfter}
+}
+
+// isMaxlagResponse reports whether Wikipedia refused a request because of
+// replication lag, which it signals with a 503 and an X-Database-Lag header.
+func isMaxlagResponse(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusServiceUnavailable &&
+		resp.Header.Get(databaseLagHeader) != ""
+}
+
+// retryAfterMaxlag handles a maxlag refusal of a search: it waits as long as
+// Retry-After asks and tries exactly once more through the breaker. It gives
+// up straight away when the wait would outlast the context deadline. The wait
+// happens outside the breaker so it doesn't hold a half-open trial slot, and
+// maxlag refusals aren't retryable by the caller, since Wikipedia is healthy
+// and only asking us to back off. Any other failure of the retry is returned
+// as fetchSearch reported it.
+func (c *WikipediaClient) retryAfterMaxlag(
+	ctx context.Context,
+	endpoint string,
+	lagErr *maxlagError,
+) (*WikipediaSearchResponse, bool, error) {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < lagErr.retryAfter {
+		wikiLog.Warnf(
+			"Wikipedia refused search for maxlag (lag %ss), retry after %s exceeds deadline (%s)",
+			lagErr.lag,
+			lagErr.retryAfter,
+			logFields(ctx),
+		)
+
+		return nil, false, lagErr
+	}
+
+	wikiLog.Warnf(
+		"Wikipedia refused search for maxlag (lag %ss), retrying once in %s (%s)",
+		lagErr.lag,
+		lagErr.retryAfter,
+		logFields(ctx),
+	)
+
+	timer := time.NewTimer(lagErr.retryAfter)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return nil, false, ctx.Err()
+	case <-timer.C:
+	}
+
+	err := waitForRateLimit(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	searchResponse, retryable, err := c.fetchSearchThroughBreaker(ctx, endpoint)
+	if errors.As(err, &lagErr) {
+		wikiLog.Warnf(
+			"Wikipedia refused search for maxlag again (lag %ss), giving up (%s)",
+			lagErr.lag,
+			logFields(ctx),
+		)
+
+		return nil, false, err
+	}
+
+	return searchResponse, retryable, err
+}
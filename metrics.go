@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	searchesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "wikipedia_demo_searches_total",
+		Help: "Total number of searches handled.",
+	})
+
+	cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wikipedia_demo_cache_requests_total",
+		Help: "Search cache lookups partitioned by result (hit, miss or stale).",
+	}, []string{"result"})
+
+	wikipediaRequestDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "wikipedia_demo_upstream_request_duration_seconds",
+		Help:    "Latency of requests to the Wikipedia API.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	searchesInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "wikipedia_demo_searches_in_flight",
+		Help: "Number of searches currently waiting on the Wikipedia API.",
+	})
+
+	circuitBreakerState = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "wikipedia_demo_circuit_breaker_state",
+		Help: "State of the Wikipedia API circuit breaker (0 closed, 1 half-open, 2 open).",
+	})
+
+	wikipediaErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wikipedia_demo_upstream_errors_total",
+		Help: "Failed requests to the Wikipedia API partitioned by status code.",
+	}, []string{"status_code"})
+)
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type correlationIDKey struct{}
+
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+func CorrelationIDFromCtx(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+type logFieldsKey struct{}
+
+// addLogFields returns a copy of ctx carrying extra key-value pairs, given
+// as alternating keys and values, for logFields to include. Fields already
+// on ctx are kept, so code further down the request can keep adding to them.
+func addLogFields(ctx context.Context, keysAndValues ...string) context.Context {
+	fields, _ := ctx.Value(logFieldsKey{}).([]string)
+	fields = append(fields[:len(fields):len(fields)], keysAndValues...)
+
+	return context.WithValue(ctx, logFieldsKey{}, fields)
+}
+
+// logFields formats the correlation ID, the trace ID of any span on ctx and
+// any fields added to ctx as space-separated key=value pairs, for the
+// parenthesised group at the end of a log line. Values containing spaces or
+// quotes are quoted.
+func logFields(ctx context.Context) string {
+	var b strings.Builder
+
+	b.WriteString("correlation_id=" + CorrelationIDFromCtx(ctx))
+
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		b.WriteString(" trace_id=" + sc.TraceID().String())
+	}
+
+	fields, _ := ctx.Value(logFieldsKey{}).([]string)
+	for i := 0; i+1 < len(fields); i += 2 {
+		value := fields[i+1]
+		if strings.ContainsAny(value, " \t\n\"") {
+			value = strconv.Quote(value)
+		}
+
+		b.WriteString(" " + fields[i] + "=" + value)
+	}
+
+	return b.String()
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 10)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+const maxCorrelationIDLength = 128
+
+// incomingCorrelationID returns the ID an upstream proxy attached to r, if
+// any. IDs that are too long or contain characters other than letters,
+// digits, '.', '_' and '-' are ignored so they can't be used to inject
+// content into logs.
+func incomingCorrelationID(r *http.Request) string {
+	for _, header := range []string{"X-Correlation-ID", "X-Request-ID"} {
+		id := r.Header.Get(header)
+		if validCorrelationID(id) {
+			return id
+		}
+	}
+
+	return ""
+}
+
+func validCorrelationID(id string) bool {
+	if id == "" || len(id) > maxCorrelationIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// quietPaths are probed frequently by infrastructure, or requested on every
+// keystroke in the case of /suggest, and are left out of the request log.
+var quietPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+	"/version": true,
+	"/metrics": true,
+	"/suggest": true,
+}
+
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	statusCode   int
+	bytesWritten int
+}
+
+func (lrw *loggingResponseWriter) WriteHeader(code int) {
+	lrw.statusCode = code
+	lrw.ResponseWriter.WriteHeader(code)
+}
+
+func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
+	n, err := lrw.ResponseWriter.Write(b)
+	lrw.bytesWritten += n
+
+	return n, err
+}
+
+// Flush passes through to the underlying writer so wrapping it doesn't hide
+// http.Flusher from handlers that stream.
+func (lrw *loggingResponseWriter) Flush() {
+	if f, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes through to the underlying writer so connection upgrades keep
+// working behind the request logger.
+func (lrw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := lrw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer for
+// deadlines and anything else not passed through above.
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
+// accessLogFormat selects the request log line: the app's own format by
+// default, or the Apache combined log format with ACCESS_LOG_FORMAT=combined.
+var accessLogFormat = "default"
+
+var accessLogger = log.New(os.Stderr, "", 0)
+
+// logCombined writes a request in the combined log format. It bypasses the
+// app's log prefix and level so the line can be parsed by tools expecting
+// Apache-style access logs.
+func logCombined(r *http.Request, lrw *loggingResponseWriter, start time.Time) {
+	size := "-"
+	if lrw.bytesWritten > 0 {
+		size = strconv.Itoa(lrw.bytesWritten)
+	}
+
+	referer := "-"
+	if v := r.Referer(); v != "" {
+		referer = redact(v)
+	}
+
+	userAgent := "-"
+	if v := r.UserAgent(); v != "" {
+		userAgent = v
+	}
+
+	accessLogger.Printf(
+		"%s - - [%s] %s %d %s %s %s",
+		clientIP(r),
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(r.Method+" "+redact(r.URL.RequestURI())+" "+r.Proto),
+		lrw.statusCode,
+		size,
+		strconv.Quote(referer),
+		strconv.Quote(userAgent),
+	)
+}
+
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		correlationID := incomingCorrelationID(r)
+		if correlationID == "" {
+			correlationID = newCorrelationID()
+		}
+
+		w.Header().Set("X-Correlation-ID", correlationID)
+
+		ctx := otel.GetTextMapPropagator().Extract(
+			r.Context(),
+			propagation.HeaderCarrier(r.Header),
+		)
+
+		ctx, span := tracer.Start(
+			ctx,
+			r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", redact(r.URL.RequestURI())),
+				attribute.String("correlation_id", correlationID),
+			),
+		)
+		defer span.End()
+
+		r = r.WithContext(WithCorrelationID(ctx, correlationID))
+
+		var visitor string
+		if !quietPaths[r.URL.Path] {
+			visitor = visitorID(w, r)
+		}
+
+		lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		next.ServeHTTP(lrw, r)
+
+		span.SetAttributes(attribute.Int("http.status_code", lrw.statusCode))
+		if lrw.statusCode >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(lrw.statusCode))
+		}
+
+		if quietPaths[r.URL.Path] {
+			return
+		}
+
+		if accessLogFormat == "combined" {
+			logCombined(r, lrw, start)
+			return
+		}
+
+		var traceID string
+		if sc := span.SpanContext(); sc.HasTraceID() {
+			traceID = sc.TraceID().String()
+		}
+
+		httpLog.Infof(
+			"%s %s completed with %d in %s (correlation_id=%s trace_id=%s visitor_id=%s client_ip=%s bytes=%d)",
+			r.Method,
+			redact(r.URL.RequestURI()),
+			lrw.statusCode,
+			time.Since(start),
+			correlationID,
+			traceID,
+			visitor,
+			clientIP(r),
+			lrw.bytesWritten,
+		)
+	})
+}
+
+// methodGuard responds with 405 Method Not Allowed to requests whose method
+// is not in allowed.
+func methodGuard(next http.Handler, allowed ...string) http.Handler {
+	allow := strings.Join(allowed, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range allowed {
+			if r.Method == method {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("Allow", allow)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	})
+}
+
+// timeoutMiddleware gives every request a deadline of d. Handlers that call
+// Wikipedia already turn context.DeadlineExceeded into a 504, so a request
+// that overruns gets the usual error page or JSON error rather than hanging.
+// A zero d disables the deadline.
+func timeoutMiddleware(next http.Handler, d time.Duration) http.Handler {
+	if d <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type recoverResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *recoverResponseWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recoverResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recoverResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack counts as writing the response, since an error page can't be sent
+// once the handler owns the connection.
+func (w *recoverResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	w.wroteHeader = true
+
+	return h.Hijack()
+}
+
+func (w *recoverResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// recoverMiddleware turns a panic in a handler into a logged error and, if
+// nothing has been written yet, a 500 error page. http.ErrAbortHandler is
+// re-raised so the server can abort the response as intended.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoverResponseWriter{ResponseWriter: w}
+
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			correlationID := CorrelationIDFromCtx(r.Context())
+
+			httpLog.Errorf(
+				"Recovered from panic: %v (%s)\n%s",
+				v,
+				logFields(r.Context()),
+				debug.Stack(),
+			)
+
+			if rw.wroteHeader {
+				return
+			}
+
+			renderError(
+				w,
+				fmt.Errorf("panic: %v", v),
+				correlationID,
+			)
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+var compressedAssetTypes = map[string]bool{
+	".png":   true,
+	".jpg":   true,
+	".jpeg":  true,
+	".gif":   true,
+	".webp":  true,
+	".ico":   true,
+	".woff":  true,
+	".woff2": true,
+	".gz":    true,
+	".zip":   true,
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+
+	w.wroteHeader = true
+
+	// Ranges refer to the uncompressed bytes, so partial responses are
+	// passed through as they are.
+	h := w.Header()
+	if code != http.StatusNoContent &&
+		code != http.StatusNotModified &&
+		code != http.StatusPartialContent &&
+		h.Get("Content-Range") == "" &&
+		h.Get("Content-Encoding") == "" {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+
+	return w.gz.Write(b)
+}
+
+// Flush sends whatever has been compressed so far, so streamed responses
+// reach the client as they are written.
+func (w *gzipResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands over the raw connection, on which nothing is compressed.
+func (w *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	return h.Hijack()
+}
+
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipResponseWriter) Close() error {
+	if w.gz == nil {
+		return nil
+	}
+
+	return w.gz.Close()
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows a gzip
+// response, either by name or through "*". A q-value of 0 rules it out.
+func acceptsGzip(acceptEncoding string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip":
+			gzipQ = qValue(params)
+		case "*":
+			anyQ = qValue(params)
+		}
+	}
+
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+
+	return anyQ > 0
+}
+
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/assets/") &&
+			compressedAssetTypes[strings.ToLower(path.Ext(r.URL.Path))] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gzw := &gzipResponseWriter{ResponseWriter: w}
+		defer gzw.Close()
+
+		next.ServeHTTP(gzw, r)
+	})
+}
+
+const defaultContentSecurityPolicy = "default-src 'self'; " +
+	"img-src 'self' https://upload.wikimedia.org; " +
+	"style-src 'self'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'"
+
+var contentSecurityPolicy = defaultContentSecurityPolicy
+
+func securityHeadersMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+
+		if contentSecurityPolicy != "" {
+			h.Set("Content-Security-Policy", contentSecurityPolicy)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
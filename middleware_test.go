@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"gzip, deflate, br", true},
+		{"deflate, GZIP;q=0.5", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0, deflate", false},
+		{"br, *", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"x-gzipped", false},
+	}
+
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %t, want %t", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	body := strings.Repeat("hello, world ", 100)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		handler        http.HandlerFunc
+		wantGzip       bool
+	}{
+		{
+			name:           "full response",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(body))
+			},
+			wantGzip: true,
+		},
+		{
+			name:           "gzip refused",
+			acceptEncoding: "gzip;q=0, deflate",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(body))
+			},
+		},
+		{
+			name:           "partial content",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Range", "bytes 0-9/1300")
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte(body[:10]))
+			},
+		},
+		{
+			name:           "content range",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Range", "bytes */1300")
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			rec := httptest.NewRecorder()
+			gzipMiddleware(tt.handler).ServeHTTP(rec, req)
+
+			gzipped := rec.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Errorf("Content-Encoding = %q, want gzip %t", rec.Header().Get("Content-Encoding"), tt.wantGzip)
+			}
+
+			if !gzipped && !strings.HasPrefix(body, rec.Body.String()) {
+				t.Errorf("body was modified: %q", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestGzipMiddlewareServesRanges(t *testing.T) {
+	handler := gzipMiddleware(http.FileServer(http.Dir("assets")))
+
+	req := httptest.NewRequest(http.MethodGet, "/style.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-9")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusPartialContent)
+	}
+
+	if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("Content-Encoding = %q, want none", enc)
+	}
+
+	if rec.Body.Len() != 10 {
+		t.Errorf("body is %d bytes, want 10", rec.Body.Len())
+	}
+}
+
+func TestLogFieldsTraceID(t *testing.T) {
+	ctx := WithCorrelationID(context.Background(), "abc")
+
+	if got := logFields(ctx); strings.Contains(got, "trace_id") {
+		t.Errorf("logFields() = %q without a span, want no trace_id", got)
+	}
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+
+	ctx = trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	ctx = addLogFields(ctx, "search_query", "golang")
+
+	want := "correlation_id=abc trace_id=4bf92f3577b34da6a3ce929d0e0e4736 search_query=golang"
+	if got := logFields(ctx); got != want {
+		t.Errorf("logFields() = %q, want %q", got, want)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	app := newTestApp(t, newFakeWikipedia(t))
+
+	mux := http.NewServeMux()
+	mux.Handle("/", handlerWithError(indexHandler))
+	mux.Handle("/search", handlerWithError(app.searchHandler))
+
+	handler := securityHeadersMiddleware(mux)
+
+	want := map[string]string{
+		"X-Content-Type-Options":  "nosniff",
+		"X-Frame-Options":         "DENY",
+		"Referrer-Policy":         "strict-origin-when-cross-origin",
+		"Content-Security-Policy": defaultContentSecurityPolicy,
+	}
+
+	for _, target := range []string{"/", "/search?q=golang"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s: status = %d, want %d", target, rec.Code, http.StatusOK)
+		}
+
+		for name, value := range want {
+			if got := rec.Header().Get(name); got != value {
+				t.Errorf("GET %s: %s = %q, want %q", target, name, got, value)
+			}
+		}
+	}
+
+	t.Run("csp disabled", func(t *testing.T) {
+		contentSecurityPolicy = ""
+		t.Cleanup(func() { contentSecurityPolicy = defaultContentSecurityPolicy })
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if got := rec.Header().Get("Content-Security-Policy"); got != "" {
+			t.Errorf("Content-Security-Policy = %q, want none", got)
+		}
+	})
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	handler := recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("kaboom")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/search?q=golang", nil)
+	req = req.WithContext(WithCorrelationID(req.Context(), "abc123"))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/html") {
+		t.Errorf("Content-Type = %q, want the HTML error page", got)
+	}
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "abc123") || !strings.Contains(body, defaultErrorMessage) {
+		t.Error("error page is missing the correlation ID or message")
+	}
+
+	if strings.Contains(body, "kaboom") {
+		t.Error("error page leaks the panic value")
+	}
+}
+
+func TestRecoverMiddlewareAfterWrite(t *testing.T) {
+	handler := recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("partial"))
+		panic("kaboom")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "partial" {
+		t.Errorf("response = %d %q, want the partial response left alone", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRecoverMiddlewareAbortHandler(t *testing.T) {
+	handler := recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler to be re-raised", v)
+		}
+	}()
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestTimeoutMiddlewareSlowHandler(t *testing.T) {
+	buf := captureLog(t)
+
+	slow := handlerWithError(func(w http.ResponseWriter, r *http.Request) error {
+		select {
+		case <-r.Context().Done():
+			return r.Context().Err()
+		case <-time.After(5 * time.Second):
+			w.Write([]byte("too late"))
+			return nil
+		}
+	})
+
+	handler := requestLogger(timeoutMiddleware(slow, 50*time.Millisecond))
+
+	start := time.Now()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("slow handler took %s, want it cut off after 50ms", elapsed)
+	}
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+
+	if !strings.Contains(rec.Body.String(), errorMessages[http.StatusGatewayTimeout]) {
+		t.Error("response is missing the timeout message")
+	}
+
+	if !strings.Contains(buf.String(), "GET /slow completed with 504") {
+		t.Errorf("request log doesn't record the 504:\n%s", buf.String())
+	}
+}
+
+func TestRequestLoggerCombinedFormat(t *testing.T) {
+	var buf bytes.Buffer
+	accessLogger.SetOutput(&buf)
+	accessLogFormat = "combined"
+
+	t.Cleanup(func() {
+		accessLogger.SetOutput(io.Discard)
+		accessLogFormat = "default"
+	})
+
+	body := strings.Repeat("x", 1234)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{
+			name: "body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(body[:1000]))
+				w.Write([]byte(body[1000:]))
+			},
+			want: `"GET /search?q=golang HTTP/1.1" 200 1234 "https://example.com/" "test-agent"`,
+		},
+		{
+			name: "no body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			want: `"GET /search?q=golang HTTP/1.1" 204 - "https://example.com/" "test-agent"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+
+			req := httptest.NewRequest(http.MethodGet, "/search?q=golang", nil)
+			req.Header.Set("Referer", "https://example.com/")
+			req.Header.Set("User-Agent", "test-agent")
+
+			requestLogger(tt.handler).ServeHTTP(httptest.NewRecorder(), req)
+
+			if got := strings.TrimSpace(buf.String()); !strings.HasSuffix(got, tt.want) {
+				t.Errorf("access log = %q, want it to end with %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestLoggerBytesWritten(t *testing.T) {
+	buf := captureLog(t)
+
+	var flusher, hijacker bool
+
+	handler := requestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flusher = w.(http.Flusher)
+		_, hijacker = w.(http.Hijacker)
+
+		w.Write([]byte(strings.Repeat("x", 1000)))
+		w.Write([]byte(strings.Repeat("y", 234)))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=golang", nil))
+
+	if !strings.Contains(buf.String(), " bytes=1234)") {
+		t.Errorf("request log doesn't record 1234 bytes:\n%s", buf.String())
+	}
+
+	if rec.Body.Len() != 1234 {
+		t.Errorf("body is %d bytes, want 1234", rec.Body.Len())
+	}
+
+	if !flusher || !hijacker {
+		t.Errorf("wrapped writer implements Flusher %t, Hijacker %t; want both", flusher, hijacker)
+	}
+}
+
+func TestWrappedWritersFlushAndHijack(t *testing.T) {
+	release := make(chan struct{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flush", func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Error("wrapped writer is not an http.Flusher")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("first\n"))
+		f.Flush()
+
+		<-release
+		w.Write([]byte("second\n"))
+	})
+	mux.HandleFunc("/hijack", func(w http.ResponseWriter, r *http.Request) {
+		h, ok := w.(http.Hijacker)
+		if !ok {
+			t.Error("wrapped writer is not an http.Hijacker")
+			return
+		}
+
+		conn, rw, err := h.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		rw.Flush()
+	})
+
+	srv := httptest.NewServer(requestLogger(recoverMiddleware(gzipMiddleware(mux))))
+	t.Cleanup(srv.Close)
+
+	var releaseOnce sync.Once
+	t.Cleanup(func() { releaseOnce.Do(func() { close(release) }) })
+
+	t.Run("flush", func(t *testing.T) {
+		resp, err := srv.Client().Get(srv.URL + "/flush")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if !resp.Uncompressed {
+			t.Error("response was not gzipped")
+		}
+
+		r := bufio.NewReader(resp.Body)
+
+		line, err := r.ReadString('\n')
+		if err != nil || line != "first\n" {
+			t.Fatalf("first line = %q, %v; want it before the handler returns", line, err)
+		}
+
+		releaseOnce.Do(func() { close(release) })
+
+		if rest, _ := io.ReadAll(r); string(rest) != "second\n" {
+			t.Errorf("rest of body = %q, want %q", rest, "second\n")
+		}
+	})
+
+	t.Run("hijack", func(t *testing.T) {
+		resp, err := srv.Client().Get(srv.URL + "/hijack")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if body, _ := io.ReadAll(resp.Body); string(body) != "hijacked" {
+			t.Errorf("body = %q, want %q", body, "hijacked")
+		}
+	})
+}
+
+func TestWrappedWritersUnwrap(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	for _, w := range []interface{ Unwrap() http.ResponseWriter }{
+		&loggingResponseWriter{ResponseWriter: rec},
+		&recoverResponseWriter{ResponseWriter: rec},
+		&gzipResponseWriter{ResponseWriter: rec},
+	} {
+		if w.Unwrap() != rec {
+			t.Errorf("%T.Unwrap() doesn't return the underlying writer", w)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+)
+
+func notFoundHandler(w http.ResponseWriter, r *http.Request) error {
+	return renderTemplate(
+		w,
+		r,
+		"notfound",
+		http.StatusNotFound,
+		struct{ Path string }{r.URL.Path},
+	)
+}
+
+func faviconHandler(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, filepath.Join(assetsDir, "favicon.ico"))
+}
+
+// exactPath routes requests for path to next and everything else to
+// notFound. It is used for the "/" pattern, which ServeMux otherwise treats
+// as a catch-all.
+func exactPath(path string, next, notFound http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
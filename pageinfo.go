@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const thumbnailSize = 120
+
+type WikipediaPageInfoResponse struct {
+	Query struct {
+		Pages map[string]struct {
+			PageID    int    `json:"pageid"`
+			FullURL   string `json:"fullurl"`
+			Thumbnail struct {
+				Source string `json:"source"`
+			} `json:"thumbnail"`
+		} `json:"pages"`
+	} `json:"query"`
+}
+
+type pageInfo struct {
+	Thumbnail string
+	FullURL   string
+}
+
+// addPageInfo looks up the thumbnail and canonical URL of every result in a
+// single batched request, since list=search returns neither. On failure the
+// results are left without images, linked by page ID instead, and the error
+// is returned so the degraded response isn't cached.
+func (c *WikipediaClient) addPageInfo(
+	ctx context.Context,
+	lang string,
+	searchResponse *WikipediaSearchResponse,
+) error {
+	results := searchResponse.Query.Search
+	if len(results) == 0 {
+		return nil
+	}
+
+	pageIDs := make([]string, 0, len(results))
+	for _, result := range results {
+		pageIDs = append(pageIDs, strconv.Itoa(result.PageID))
+	}
+
+	pages, err := c.PageInfo(ctx, lang, pageIDs)
+	if err != nil {
+		return err
+	}
+
+	for i := range results {
+		page := pages[results[i].PageID]
+		results[i].Thumbnail = page.Thumbnail
+		results[i].FullURL = page.FullURL
+	}
+
+	return nil
+}
+
+func (c *WikipediaClient) PageInfo(
+	ctx context.Context,
+	lang string,
+	pageIDs []string,
+) (map[int]pageInfo, error) {
+	endpoint := c.endpoint(lang, url.Values{
+		"action":      {"query"},
+		"prop":        {"pageimages|info"},
+		"inprop":      {"url"},
+		"piprop":      {"thumbnail"},
+		"format":      {"json"},
+		"origin":      {"*"},
+		"pithumbsize": {strconv.Itoa(thumbnailSize)},
+		"pilimit":     {strconv.Itoa(len(pageIDs))},
+		"pageids":     {strings.Join(pageIDs, "|")},
+	})
+
+	err := waitForRateLimit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"non 200 OK response from Wikipedia API: %s",
+			resp.Status,
+		)
+	}
+
+	var infoResponse WikipediaPageInfoResponse
+
+	err = json.NewDecoder(resp.Body).Decode(&infoResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make(map[int]pageInfo, len(infoResponse.Query.Pages))
+	for _, page := range infoResponse.Query.Pages {
+		pages[page.PageID] = pageInfo{
+			Thumbnail: page.Thumbnail.Source,
+			FullURL:   page.FullURL,
+		}
+	}
+
+	return pages, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	pageSizeCookieName   = "pageSize"
+	pageSizeCookieMaxAge = 365 * 24 * time.Hour
+)
+
+// pageSizeOptions are offered by the results-per-page selector.
+var pageSizeOptions = []int{10, 20, 50}
+
+// PageSizeOptions returns the selector's choices, including the current page
+// size if it was set to something else through the limit param.
+func (s *Search) PageSizeOptions() []int {
+	for _, size := range pageSizeOptions {
+		if size == s.Limit {
+			return pageSizeOptions
+		}
+	}
+
+	options := append([]int{s.Limit}, pageSizeOptions...)
+	sort.Ints(options)
+
+	return options
+}
+
+// preferredPageSize returns the page size remembered in the visitor's
+// cookie, or an empty string if there is none. It is validated like the
+// limit param it stands in for.
+func preferredPageSize(r *http.Request) string {
+	cookie, err := r.Cookie(pageSizeCookieName)
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+// rememberPageSize stores size in the visitor's cookie so that later
+// searches without a limit param use it, unless it's already stored.
+func rememberPageSize(w http.ResponseWriter, r *http.Request, size int) {
+	value := strconv.Itoa(size)
+	if preferredPageSize(r) == value {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     pageSizeCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(pageSizeCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const defaultWikiRateLimit = 10
+
+var wikipediaLimiter = newWikipediaLimiter(defaultWikiRateLimit)
+
+func newWikipediaLimiter(rps float64) *rate.Limiter {
+	burst := int(math.Ceil(rps))
+	if burst < 1 {
+		burst = 1
+	}
+
+	return rate.NewLimiter(rate.Limit(rps), burst)
+}
+
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf(
+		"Wikipedia API rate limit reached, retry after %s",
+		e.retryAfter,
+	)
+}
+
+func (e *rateLimitError) Status() int {
+	return http.StatusServiceUnavailable
+}
+
+func (e *rateLimitError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// waitForRateLimit blocks until the next outbound Wikipedia request is
+// allowed. It gives up straight away with a rateLimitError when the wait
+// would outlast the context deadline.
+func waitForRateLimit(ctx context.Context) error {
+	reservation := wikipediaLimiter.Reserve()
+
+	delay := reservation.Delay()
+	if delay == 0 {
+		return nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		reservation.Cancel()
+		wikiLog.Warnf("Wikipedia request rejected by rate limiter, wait of %s exceeds deadline", delay)
+
+		return &rateLimitError{retryAfter: delay}
+	}
+
+	wikiLog.Warnf("Throttling Wikipedia request for %s", delay)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		reservation.Cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var retryErr interface{ RetryAfter() time.Duration }
+	if !errors.As(err, &retryErr) {
+		return
+	}
+
+	seconds := int(math.Ceil(retryErr.RetryAfter().Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+const redactedText = "[REDACTED]"
+
+// defaultRedactPatterns match values that users sometimes paste into the
+// search box and that should not end up in logs: email addresses (including
+// URL-encoded ones), well-known API key formats, and long hex or base64
+// strings.
+var defaultRedactPatterns = []string{
+	`[A-Za-z0-9._%+-]+(?:@|%40)[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	`\b(?:sk|pk|rk)_(?:live|test)_[A-Za-z0-9]{10,}`,
+	`\bgh[pousr]_[A-Za-z0-9]{20,}`,
+	`\bxox[abprs]-[A-Za-z0-9-]{10,}`,
+	`\bAKIA[0-9A-Z]{16}\b`,
+	`\b[0-9A-Fa-f]{32,}\b`,
+	`[A-Za-z0-9+/_-]{40,}={0,2}`,
+}
+
+var redactPatterns = compileRedactPatterns(defaultRedactPatterns)
+
+func compileRedactPatterns(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Fatalf("Invalid redaction pattern '%s': %v", pattern, err)
+		}
+
+		compiled = append(compiled, re)
+	}
+
+	return compiled
+}
+
+// parseRedactPatterns splits the LOG_REDACT_PATTERNS value, a whitespace
+// separated list of regular expressions, and compiles it.
+func parseRedactPatterns(s string) []*regexp.Regexp {
+	return compileRedactPatterns(strings.Fields(s))
+}
+
+// redact replaces anything in s that looks like a secret or personal data
+// with a placeholder. It is applied to user-supplied values before they are
+// logged; the original values are still used for the request itself.
+func redact(s string) string {
+	for _, re := range redactPatterns {
+		s = re.ReplaceAllString(s, redactedText)
+	}
+
+	return s
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// maxPooledBufferSize keeps the occasional very large page from pinning its
+// buffer in the pool forever.
+const maxPooledBufferSize = 1 << 20
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns buf to the pool. It must only be called once everything
+// in buf has been written out.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+const templatesDir = "templates"
+
+var templateFuncs = template.FuncMap{
+	"asset": assetPath,
+}
+
+// templates holds every page in templatesDir, keyed by its file name without
+// the extension.
+var templates map[string]*template.Template
+
+// parseTemplates parses each page in dir on top of the shared layouts in
+// dir/layouts. Pages are parsed separately so each can define its own
+// "title", "content" and other blocks used by the base layout.
+func parseTemplates(dir string) (map[string]*template.Template, error) {
+	pages, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := make(map[string]*template.Template, len(pages))
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".html")
+
+		t, err := parsePage(dir, name)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed[name] = t
+	}
+
+	return parsed, nil
+}
+
+func parsePage(dir, name string) (*template.Template, error) {
+	layouts, err := template.New("").
+		Funcs(templateFuncs).
+		ParseGlob(filepath.Join(dir, "layouts", "*.html"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse layouts: %w", err)
+	}
+
+	t, err := layouts.ParseFiles(filepath.Join(dir, name+".html"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s template: %w", name, err)
+	}
+
+	return t.Lookup(name + ".html"), nil
+}
+
+// pageTemplate returns the named page template. In development the page is
+// parsed again on every call, so edits show up without a restart. Each
+// request gets its own copy, leaving templates untouched and safe to share.
+func pageTemplate(name string) (*template.Template, error) {
+	if isDevelopment {
+		return parsePage(templatesDir, name)
+	}
+
+	t, ok := templates[name]
+	if !ok {
+		return nil, fmt.Errorf("no template named '%s'", name)
+	}
+
+	return t, nil
+}
+
+// streamTemplates makes renderTemplate execute templates straight into the
+// response instead of buffering them first. It saves holding a copy of large
+// pages in memory, but a template error part-way through can no longer be
+// turned into an error page, as the status and part of the body have already
+// been sent.
+var streamTemplates = false
+
+// renderTemplate executes the named page template with data and writes the
+// result with the given status.
+func renderTemplate(
+	w http.ResponseWriter,
+	r *http.Request,
+	name string,
+	status int,
+	data any,
+) error {
+	t, err := pageTemplate(name)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if streamTemplates {
+		w.WriteHeader(status)
+
+		err = t.Execute(w, data)
+		if err != nil {
+			logErrorf(
+				"Unable to render %s after the response started: %v (%s)",
+				name,
+				err,
+				logFields(r.Context()),
+			)
+		}
+
+		return nil
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	err = t.Execute(buf, data)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(status)
+
+	_, err = buf.WriteTo(w)
+
+	return err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// benchmarkSearch returns a Search for the first page of 45 results, as
+// searchHandler would render it.
+func benchmarkSearch() *Search {
+	results := fakeSearchResponse("golang", 45, defaultPageSize, 0)
+
+	for i := range results.Query.Search {
+		results.Query.Search[i].FullURL = "https://en.wikipedia.org/wiki/Golang_result"
+		results.Query.Search[i].Thumbnail = "https://upload.wikimedia.org/thumb.jpg"
+	}
+
+	return &Search{
+		Query:          "golang",
+		Lang:           defaultLanguage,
+		Sort:           defaultSort,
+		Highlight:      true,
+		NewTab:         true,
+		Limit:          defaultPageSize,
+		TotalHits:      results.Query.SearchInfo.TotalHits,
+		TotalPages:     3,
+		NextPage:       2,
+		ContinueOffset: results.Continue.Sroffset,
+		Results:        results,
+	}
+}
+
+func TestSearchRenderSuggestionLinks(t *testing.T) {
+	search := benchmarkSearch()
+	search.Query = "teh & co"
+	search.Namespace = "0|14"
+	search.Highlight = false
+	search.CorrectedFrom = "teh & co"
+	search.Suggestion = "the & co"
+
+	var b strings.Builder
+	if err := templates["index"].Execute(&b, search); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, q := range []string{search.CorrectedFrom, search.Suggestion} {
+		link := search.QueryURL(q)
+
+		params, err := url.ParseQuery(strings.TrimPrefix(link, "/search?"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if params.Get("q") != q || params.Get("namespace") != "0|14" || params.Get("highlight") != "false" {
+			t.Errorf("QueryURL(%q) = %q, want the query with the current namespace and highlight", q, link)
+		}
+
+		if !strings.Contains(html.UnescapeString(b.String()), `href="`+link+`"`) {
+			t.Errorf("page is missing a link to %q", link)
+		}
+	}
+}
+
+func BenchmarkSearchRender(b *testing.B) {
+	search := benchmarkSearch()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		err := templates["index"].Execute(io.Discard, search)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRenderTemplate(b *testing.B) {
+	search := benchmarkSearch()
+	req := httptest.NewRequest(http.MethodGet, "/search?q=golang", nil)
+
+	for _, stream := range []bool{false, true} {
+		name := "buffered"
+		if stream {
+			name = "streamed"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			streamTemplates = stream
+			defer func() { streamTemplates = false }()
+
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				err := renderTemplate(discardResponseWriter{}, req, "index", http.StatusOK, search)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// discardResponseWriter is a ResponseWriter that throws the response away,
+// so benchmarks measure rendering rather than recording.
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Header() http.Header {
+	return http.Header{}
+}
+
+func (discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (discardResponseWriter) WriteHeader(int) {}
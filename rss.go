@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+)
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Language    string    `xml:"language"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	Description rssHTML `xml:"description"`
+	PubDate     string  `xml:"pubDate,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssHTML struct {
+	Value string `xml:",cdata"`
+}
+
+// searchRSSHandler renders the same search as /search as an RSS 2.0 feed, so
+// a query can be followed in a feed reader.
+func (app *application) searchRSSHandler(w http.ResponseWriter, r *http.Request) error {
+	search, err := app.runSearch(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == http.MethodHead {
+		return writeHead(w, "application/rss+xml; charset=utf-8")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       "Wikipedia search: " + search.Query,
+			Link:        scheme + "://" + r.Host + search.PageURL(search.CurrentPage()),
+			Description: "Wikipedia articles matching '" + search.Query + "'",
+			Language:    search.Lang,
+			Items:       []rssItem{},
+		},
+	}
+
+	for _, result := range search.Results.Query.Search {
+		link := result.URL(search.Lang)
+
+		item := rssItem{
+			Title:       result.Title,
+			Link:        link,
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+			Description: rssHTML{Value: string(search.Snippet(result.Snippet))},
+		}
+
+		if !result.Timestamp.IsZero() {
+			item.PubDate = result.Timestamp.Format(time.RFC1123Z)
+		}
+
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+
+	_, err = w.Write([]byte(xml.Header))
+	if err != nil {
+		return err
+	}
+
+	return xml.NewEncoder(w).Encode(feed)
+}
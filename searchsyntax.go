@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// searchOperators are the CirrusSearch keywords users can put in a query,
+// for example intitle:"exact phrase", incategory:Physics or
+// hastemplate:Infobox. A leading "-" or "!" negates them. The query is sent
+// to Wikipedia as-is in srsearch, so these work without any translation; the
+// checks below only catch syntax Wikipedia would reject or misread.
+var searchOperators = []string{
+	"intitle",
+	"incategory",
+	"deepcat",
+	"deepcategory",
+	"hastemplate",
+	"insource",
+	"linksto",
+	"prefix",
+	"morelike",
+	"inlanguage",
+	"articletopic",
+	"subpageof",
+	"filetype",
+	"filemime",
+	"filesize",
+	"fileres",
+	"filew",
+	"fileh",
+	"filebits",
+	"boost-templates",
+}
+
+// validateSearchQuery rejects queries with unbalanced double quotes, known
+// operators without a value, and insource:/regex/ values that are not
+// terminated.
+func validateSearchQuery(q string) error {
+	if strings.Count(q, `"`)%2 != 0 {
+		return fmt.Errorf("search query has an unterminated quoted phrase")
+	}
+
+	for _, term := range strings.Fields(q) {
+		term = strings.TrimLeft(term, "-!")
+
+		keyword, value, ok := strings.Cut(term, ":")
+		if !ok || !isSearchOperator(keyword) {
+			continue
+		}
+
+		if value == "" {
+			return fmt.Errorf("search operator '%s:' is missing a value", keyword)
+		}
+
+		if strings.EqualFold(keyword, "insource") &&
+			strings.HasPrefix(value, "/") &&
+			(len(value) < 2 || !strings.HasSuffix(strings.TrimSuffix(value, "i"), "/")) {
+			return fmt.Errorf("search operator 'insource:' has an unterminated regular expression")
+		}
+	}
+
+	return nil
+}
+
+func isSearchOperator(keyword string) bool {
+	for _, op := range searchOperators {
+		if strings.EqualFold(keyword, op) {
+			return true
+		}
+	}
+
+	return false
+}
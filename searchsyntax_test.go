@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestValidateSearchQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		valid bool
+	}{
+		{`intitle:"exact phrase"`, true},
+		{"hastemplate:Infobox", true},
+		{`incategory:"Living people" -intitle:draft`, true},
+		{`"rock and roll" hall of fame`, true},
+		{"insource:/foo.*bar/i", true},
+		{"C++ & C#", true},
+		{"time: 12:30", true},
+		{`intitle:"exact phrase`, false},
+		{`"unterminated`, false},
+		{"intitle:", false},
+		{"-hastemplate: Infobox", false},
+		{"insource:/unterminated", false},
+	}
+
+	for _, tt := range tests {
+		err := validateSearchQuery(tt.query)
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("validateSearchQuery(%q) = %v, want valid %t", tt.query, err, tt.valid)
+		}
+	}
+}
+
+func TestSearchPassesOperators(t *testing.T) {
+	fake := newFakeWikipedia(t)
+	app := newTestApp(t, fake)
+
+	for _, query := range []string{`intitle:"exact phrase"`, "hastemplate:Infobox"} {
+		t.Run(query, func(t *testing.T) {
+			rec := serveSearch(app, "/search?"+url.Values{"q": {query}}.Encode())
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			req := fake.lastSearch.Load().(*http.Request)
+			if got := req.URL.Query().Get("srsearch"); got != query {
+				t.Errorf("srsearch = %q, want %q", got, query)
+			}
+		})
+	}
+
+	rec := serveSearch(app, "/search?"+url.Values{"q": {`intitle:"exact phrase`}}.Encode())
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed operator: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// snippetMaxLength caps the number of characters of text shown in a rendered
+// snippet. Zero disables truncation.
+var snippetMaxLength = 0
+
+const snippetEllipsis = "…"
+
+// sanitizeSnippet re-renders a search snippet from Wikipedia, keeping only
+// the <span class="searchmatch"> highlights and escaping everything else.
+// If maxLength is positive, the text is cut at a word boundary after that
+// many characters. Every span it opens is closed, even if the input or the
+// output is truncated mid-tag.
+func sanitizeSnippet(snippet string, maxLength int) template.HTML {
+	var b strings.Builder
+
+	depth := 0
+	remaining := maxLength
+
+	z := html.NewTokenizer(strings.NewReader(snippet))
+
+tokens:
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				logDebugf("Unable to tokenize snippet: %v", z.Err())
+			}
+
+			break
+		}
+
+		token := z.Token()
+
+		switch tt {
+		case html.TextToken:
+			text := token.Data
+
+			if maxLength > 0 {
+				n := utf8.RuneCountInString(text)
+				if n > remaining {
+					text = truncateWords(text, remaining, remaining < maxLength)
+					b.WriteString(html.EscapeString(text) + snippetEllipsis)
+
+					break tokens
+				}
+
+				remaining -= n
+			}
+
+			b.WriteString(html.EscapeString(text))
+		case html.StartTagToken:
+			if token.Data == "span" && isSearchMatch(token) {
+				b.WriteString(`<span class="searchmatch">`)
+				depth++
+			}
+		case html.EndTagToken:
+			if token.Data == "span" && depth > 0 {
+				b.WriteString("</span>")
+				depth--
+			}
+		}
+	}
+
+	b.WriteString(strings.Repeat("</span>", depth))
+
+	return template.HTML(b.String())
+}
+
+// truncateSnippetText shortens plain snippet text to at most maxLength
+// characters at a word boundary, adding an ellipsis if anything was removed.
+func truncateSnippetText(text string, maxLength int) string {
+	if maxLength <= 0 || utf8.RuneCountInString(text) <= maxLength {
+		return text
+	}
+
+	return truncateWords(text, maxLength, false) + snippetEllipsis
+}
+
+// truncateWords returns the first n characters of text, backing up to the
+// last whitespace so a word isn't cut in half. If text has no whitespace
+// before the cut, it is cut mid-word unless continued is set, meaning text
+// follows earlier output and can be dropped entirely instead.
+func truncateWords(text string, n int, continued bool) string {
+	runes := []rune(text)
+	if n >= len(runes) {
+		return text
+	}
+
+	if unicode.IsSpace(runes[n]) {
+		return strings.TrimRightFunc(string(runes[:n]), unicode.IsSpace)
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		if unicode.IsSpace(runes[i]) {
+			return strings.TrimRightFunc(string(runes[:i]), unicode.IsSpace)
+		}
+	}
+
+	if continued {
+		return ""
+	}
+
+	return string(runes[:n])
+}
+
+func isSearchMatch(token html.Token) bool {
+	for _, attr := range token.Attr {
+		if attr.Key == "class" && attr.Val == "searchmatch" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stripHighlights returns the plain text of a search snippet with all markup
+// removed. The result is not escaped.
+func stripHighlights(snippet string) string {
+	var b strings.Builder
+
+	z := html.NewTokenizer(strings.NewReader(snippet))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		if tt == html.TextToken {
+			b.Write(z.Text())
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeSnippet(t *testing.T) {
+	tests := []struct {
+		snippet   string
+		maxLength int
+		want      string
+	}{
+		{
+			`about <span class="searchmatch">golang</span> programming`,
+			0,
+			`about <span class="searchmatch">golang</span> programming`,
+		},
+		{
+			`about <span class="searchmatch">golang</span> programming`,
+			12,
+			`about <span class="searchmatch">golang</span>…`,
+		},
+		{
+			`<span class="searchmatch">rock and roll</span> music`,
+			8,
+			`<span class="searchmatch">rock and…</span>`,
+		},
+		{
+			`foo <span class="searchmatch">bar`,
+			0,
+			`foo <span class="searchmatch">bar</span>`,
+		},
+		{
+			`a &lt;b&gt; <b onclick="x()">bold</b> <span class="other">text</span>`,
+			0,
+			`a &lt;b&gt; bold text`,
+		},
+		{
+			`日本語のテキスト`,
+			3,
+			`日本語…`,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := string(sanitizeSnippet(tt.snippet, tt.maxLength)); got != tt.want {
+			t.Errorf("sanitizeSnippet(%q, %d) = %q, want %q", tt.snippet, tt.maxLength, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeSnippetTruncationKeepsMarkupBalanced(t *testing.T) {
+	const snippet = `The <span class="searchmatch">Go</span> programming language, ` +
+		`also known as <span class="searchmatch">Golang</span>, is a ` +
+		`statically typed, compiled <span class="searchmatch">high-level</span> language`
+
+	for maxLength := 1; maxLength <= 120; maxLength++ {
+		got := string(sanitizeSnippet(snippet, maxLength))
+
+		opened := strings.Count(got, `<span class="searchmatch">`)
+		if closed := strings.Count(got, "</span>"); opened != closed {
+			t.Errorf("maxLength %d: %q opens %d spans and closes %d", maxLength, got, opened, closed)
+		}
+
+		text := strings.NewReplacer(`<span class="searchmatch">`, "", "</span>", "").Replace(got)
+		if strings.ContainsAny(text, "<>") {
+			t.Errorf("maxLength %d: %q contains a partial tag", maxLength, got)
+		}
+
+		if n := utf8.RuneCountInString(strings.TrimSuffix(text, snippetEllipsis)); n > maxLength {
+			t.Errorf("maxLength %d: %q has %d characters of text", maxLength, got, n)
+		}
+	}
+}
+
+func TestTruncateSnippetText(t *testing.T) {
+	tests := []struct {
+		text      string
+		maxLength int
+		want      string
+	}{
+		{"short", 10, "short"},
+		{"rock and roll", 0, "rock and roll"},
+		{"rock and roll", 10, "rock and…"},
+		{"rock and roll", 8, "rock and…"},
+		{"supercalifragilistic", 5, "super…"},
+	}
+
+	for _, tt := range tests {
+		if got := truncateSnippetText(tt.text, tt.maxLength); got != tt.want {
+			t.Errorf("truncateSnippetText(%q, %d) = %q, want %q", tt.text, tt.maxLength, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	statsWindow      = time.Hour
+	statsBucketWidth = time.Minute
+
+	defaultStatsTopN       = 10
+	defaultStatsMaxQueries = 1000
+)
+
+// statsTopN is the number of queries reported by /stats.
+var statsTopN = defaultStatsTopN
+
+var queryStats = NewQueryStats(statsWindow, statsBucketWidth, defaultStatsMaxQueries)
+
+type statsBucket struct {
+	start  time.Time
+	counts map[string]int
+}
+
+// QueryStats counts search queries over a rolling window. Counts are kept in
+// fixed-width time buckets that are dropped once they fall out of the window,
+// and at most maxQueries distinct queries are tracked at a time; new queries
+// are ignored until old ones age out.
+type QueryStats struct {
+	mu         sync.Mutex
+	window     time.Duration
+	width      time.Duration
+	maxQueries int
+	buckets    []statsBucket
+	totals     map[string]int
+}
+
+func NewQueryStats(window, width time.Duration, maxQueries int) *QueryStats {
+	return &QueryStats{
+		window:     window,
+		width:      width,
+		maxQueries: maxQueries,
+		buckets:    make([]statsBucket, int(window/width)),
+		totals:     make(map[string]int),
+	}
+}
+
+func (s *QueryStats) Record(query string, now time.Time) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(now)
+
+	if _, ok := s.totals[query]; !ok && len(s.totals) >= s.maxQueries {
+		return
+	}
+
+	start := now.Truncate(s.width)
+	b := &s.buckets[int(start.UnixNano()/int64(s.width))%len(s.buckets)]
+	if b.counts == nil {
+		b.start = start
+		b.counts = make(map[string]int)
+	}
+
+	b.counts[query]++
+	s.totals[query]++
+}
+
+// expire drops the buckets that started a full window or more before now.
+func (s *QueryStats) expire(now time.Time) {
+	for i := range s.buckets {
+		b := &s.buckets[i]
+		if b.counts == nil || now.Sub(b.start) < s.window {
+			continue
+		}
+
+		for query, count := range b.counts {
+			s.totals[query] -= count
+			if s.totals[query] <= 0 {
+				delete(s.totals, query)
+			}
+		}
+
+		*b = statsBucket{}
+	}
+}
+
+type queryCount struct {
+	Query string `json:"query"`
+	Count int    `json:"count"`
+}
+
+// Top returns the n most frequent queries in the window, most frequent first.
+func (s *QueryStats) Top(n int, now time.Time) []queryCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(now)
+
+	top := make([]queryCount, 0, len(s.totals))
+	for query, count := range s.totals {
+		top = append(top, queryCount{Query: query, Count: count})
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+
+		return top[i].Query < top[j].Query
+	})
+
+	if len(top) > n {
+		top = top[:n]
+	}
+
+	return top
+}
+
+type statsResponse struct {
+	Window  string       `json:"window"`
+	Queries []queryCount `json:"queries"`
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, statsResponse{
+		Window:  statsWindow.String(),
+		Queries: queryStats.Top(statsTopN, time.Now()),
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultStreamPages = 3
+	maxStreamPages     = 5
+)
+
+// searchStreamHandler sends successive pages of results for a query as
+// server-sent events, one "results" event per page followed by "done". An
+// upstream failure part-way through ends the stream with an "error" event,
+// and a client disconnect stops it without fetching further pages.
+func (app *application) searchStreamHandler(w http.ResponseWriter, r *http.Request) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("response writer does not support streaming")
+	}
+
+	pages, err := strconv.Atoi(r.URL.Query().Get("pages"))
+	if err != nil || pages < 1 {
+		pages = defaultStreamPages
+	}
+
+	if pages > maxStreamPages {
+		pages = maxStreamPages
+	}
+
+	// The first page is fetched before anything is written so invalid
+	// parameters still get a regular JSON error with the right status.
+	search, err := app.runSearch(pageRequest(r, 1))
+	if err != nil {
+		return err
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	err = writeEvent(w, flusher, "results", newAPISearchResponse(search))
+	if err != nil {
+		return nil
+	}
+
+	for page := 2; page <= pages && page <= search.TotalPages; page++ {
+		search, err = app.runSearch(pageRequest(r, page))
+		if r.Context().Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			correlationID := CorrelationIDFromCtx(r.Context())
+
+			httpLog.Logf(errorLogLevel(err), "%s (%s)", redact(err.Error()), logFields(r.Context()))
+			writeEvent(w, flusher, "error", newAPIError(err, correlationID))
+
+			return nil
+		}
+
+		err = writeEvent(w, flusher, "results", newAPISearchResponse(search))
+		if err != nil {
+			return nil
+		}
+	}
+
+	writeEvent(w, flusher, "done", struct{}{})
+
+	return nil
+}
+
+// pageRequest returns a copy of r asking for the given page of results.
+func pageRequest(r *http.Request, page int) *http.Request {
+	params := r.URL.Query()
+	params.Set("page", strconv.Itoa(page))
+	params.Del("sroffset")
+
+	r = r.Clone(r.Context())
+	r.URL.RawQuery = params.Encode()
+
+	return r
+}
+
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	if err != nil {
+		return err
+	}
+
+	flusher.Flush()
+
+	return nil
+}
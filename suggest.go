@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	suggestLimit   = 10
+	suggestTimeout = 2 * time.Second
+)
+
+// Suggest returns up to limit article titles starting with prefix using the
+// opensearch API.
+func (c *WikipediaClient) Suggest(
+	ctx context.Context,
+	lang, prefix string,
+	limit int,
+) ([]string, error) {
+	endpoint := c.endpoint(lang, url.Values{
+		"action":    {"opensearch"},
+		"search":    {prefix},
+		"limit":     {strconv.Itoa(limit)},
+		"namespace": {"0"},
+		"format":    {"json"},
+		"origin":    {"*"},
+	})
+
+	err := waitForRateLimit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"non 200 OK response from Wikipedia API: %s",
+			resp.Status,
+		)
+	}
+
+	var body json.RawMessage
+
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	// On failure opensearch returns the usual error object instead of its
+	// [query, titles, descriptions, urls] array.
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "{") {
+		var errorResponse struct {
+			Error *WikipediaAPIError `json:"error"`
+		}
+
+		err = json.Unmarshal(body, &errorResponse)
+		if err != nil {
+			return nil, err
+		}
+
+		if errorResponse.Error != nil {
+			return nil, errorResponse.Error
+		}
+
+		return nil, fmt.Errorf("unexpected opensearch response: %s", body)
+	}
+
+	var parts []json.RawMessage
+
+	err = json.Unmarshal(body, &parts)
+	if err != nil {
+		return nil, err
+	}
+
+	titles := []string{}
+	if len(parts) > 1 {
+		err = json.Unmarshal(parts[1], &titles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return titles, nil
+}
+
+func (app *application) suggestHandler(w http.ResponseWriter, r *http.Request) error {
+	params := r.URL.Query()
+
+	prefix := strings.TrimSpace(params.Get("q"))
+	if prefix == "" || queryBlocked(prefix) {
+		return writeJSON(w, http.StatusOK, []string{})
+	}
+
+	lang := params.Get("lang")
+	if !wikipediaLanguages[lang] {
+		lang = defaultLanguage
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), suggestTimeout)
+	defer cancel()
+
+	titles, err := app.wiki.Suggest(ctx, lang, prefix, suggestLimit)
+	if err != nil {
+		var statusErr StatusError
+		if errors.As(err, &statusErr) {
+			return err
+		}
+
+		if errors.Is(err, context.DeadlineExceeded) {
+			return newStatusError(http.StatusGatewayTimeout, err)
+		}
+
+		return newStatusError(http.StatusBadGateway, err)
+	}
+
+	return writeJSON(w, http.StatusOK, titles)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultPerIPMaxClients = 10000
+	perIPIdleTimeout       = 10 * time.Minute
+)
+
+// throttledPaths are the endpoints that reach Wikipedia and are therefore
+// limited per client IP.
+var throttledPaths = map[string]bool{
+	"/search":        true,
+	"/search/stream": true,
+	"/search.rss":    true,
+	"/api/search":    true,
+	"/suggest":       true,
+}
+
+// clientLimiters is nil, and clients are not throttled, unless PER_IP_RPS
+// is set.
+var clientLimiters *ClientLimiters
+
+type clientLimiterEntry struct {
+	ip       string
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ClientLimiters hands out a token bucket per client IP. Buckets are kept in
+// least recently used order so that at most maxClients are held at once, and
+// those idle for longer than the idle timeout are dropped.
+type ClientLimiters struct {
+	mu          sync.Mutex
+	rps         rate.Limit
+	burst       int
+	maxClients  int
+	idleTimeout time.Duration
+	ll          *list.List
+	items       map[string]*list.Element
+}
+
+func NewClientLimiters(rps float64, burst, maxClients int, idleTimeout time.Duration) *ClientLimiters {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rps)))
+	}
+
+	return &ClientLimiters{
+		rps:         rate.Limit(rps),
+		burst:       burst,
+		maxClients:  maxClients,
+		idleTimeout: idleTimeout,
+		ll:          list.New(),
+		items:       make(map[string]*list.Element),
+	}
+}
+
+// Allow takes a token from ip's bucket. When the bucket is empty it returns
+// false and how long until the next token is available.
+func (c *ClientLimiters) Allow(ip string, now time.Time) (bool, time.Duration) {
+	limiter := c.limiter(ip, now)
+
+	reservation := limiter.ReserveN(now, 1)
+
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return true, 0
+	}
+
+	reservation.CancelAt(now)
+
+	return false, delay
+}
+
+func (c *ClientLimiters) limiter(ip string, now time.Time) *rate.Limiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for el := c.ll.Back(); el != nil; el = c.ll.Back() {
+		if now.Sub(el.Value.(*clientLimiterEntry).lastSeen) < c.idleTimeout {
+			break
+		}
+
+		c.removeElement(el)
+	}
+
+	if el, ok := c.items[ip]; ok {
+		entry := el.Value.(*clientLimiterEntry)
+		entry.lastSeen = now
+		c.ll.MoveToFront(el)
+
+		return entry.limiter
+	}
+
+	entry := &clientLimiterEntry{
+		ip:       ip,
+		limiter:  rate.NewLimiter(c.rps, c.burst),
+		lastSeen: now,
+	}
+	c.items[ip] = c.ll.PushFront(entry)
+
+	for c.maxClients > 0 && c.ll.Len() > c.maxClients {
+		c.removeElement(c.ll.Back())
+	}
+
+	return entry.limiter
+}
+
+func (c *ClientLimiters) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*clientLimiterEntry).ip)
+}
+
+type clientThrottledError struct {
+	retryAfter time.Duration
+}
+
+func (e *clientThrottledError) Error() string {
+	return "too many requests from this client"
+}
+
+func (e *clientThrottledError) Status() int {
+	return http.StatusTooManyRequests
+}
+
+func (e *clientThrottledError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+// throttleMiddleware answers requests to throttledPaths with a 429 once the
+// client IP has used up its allowance.
+func throttleMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if clientLimiters == nil || !throttledPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := clientIP(r)
+
+		ok, retryAfter := clientLimiters.Allow(ip, time.Now())
+		if ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		httpLog.Warnf("Throttled client %s on %s (%s)", ip, r.URL.Path, logFields(r.Context()))
+
+		err := &clientThrottledError{retryAfter: retryAfter}
+		setRetryAfter(w, err)
+
+		if strings.HasPrefix(r.URL.Path, "/api/") ||
+			r.URL.Path == "/suggest" ||
+			r.URL.Path == "/search/stream" ||
+			prefersJSON(r.Header.Get("Accept")) {
+			writeJSON(
+				w,
+				http.StatusTooManyRequests,
+				newAPIError(err, CorrelationIDFromCtx(r.Context())),
+			)
+
+			return
+		}
+
+		renderError(w, err, CorrelationIDFromCtx(r.Context()))
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientLimitersBurst(t *testing.T) {
+	limiters := NewClientLimiters(1, 3, 100, time.Minute)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiters.Allow("192.0.2.1", now); !ok {
+			t.Fatalf("request %d from one IP was throttled within the burst", i+1)
+		}
+	}
+
+	ok, retryAfter := limiters.Allow("192.0.2.1", now)
+	if ok {
+		t.Fatal("request beyond the burst was allowed")
+	}
+
+	if retryAfter <= 0 || retryAfter > time.Second {
+		t.Errorf("retry after %s, want up to 1s", retryAfter)
+	}
+
+	for i := 0; i < 3; i++ {
+		ip := fmt.Sprintf("192.0.2.%d", 10+i)
+		if ok, _ := limiters.Allow(ip, now); !ok {
+			t.Errorf("first request from %s was throttled", ip)
+		}
+	}
+
+	if ok, _ := limiters.Allow("192.0.2.1", now.Add(time.Second)); !ok {
+		t.Error("request after the bucket refilled was throttled")
+	}
+}
+
+func TestClientLimitersEviction(t *testing.T) {
+	limiters := NewClientLimiters(1, 1, 2, time.Minute)
+	now := time.Now()
+
+	limiters.Allow("192.0.2.1", now)
+	limiters.Allow("192.0.2.2", now)
+	limiters.Allow("192.0.2.3", now)
+
+	if got := limiters.ll.Len(); got != 2 {
+		t.Errorf("holding %d clients, want at most 2", got)
+	}
+
+	if ok, _ := limiters.Allow("192.0.2.1", now); !ok {
+		t.Error("least recently used client wasn't evicted")
+	}
+
+	if ok, _ := limiters.Allow("192.0.2.3", now.Add(2*time.Minute)); !ok {
+		t.Error("idle client wasn't expired")
+	}
+
+	if got := limiters.ll.Len(); got != 1 {
+		t.Errorf("holding %d clients after the others went idle, want 1", got)
+	}
+}
+
+func TestThrottleMiddleware(t *testing.T) {
+	clientLimiters = NewClientLimiters(1, 3, 100, time.Minute)
+	t.Cleanup(func() { clientLimiters = nil })
+
+	handler := throttleMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(target, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.RemoteAddr = ip + ":1234"
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	t.Run("one IP", func(t *testing.T) {
+		var codes []int
+		for i := 0; i < 5; i++ {
+			rec := serve("/search?q=golang", "198.51.100.1")
+			codes = append(codes, rec.Code)
+
+			if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+				t.Error("429 response has no Retry-After header")
+			}
+		}
+
+		want := []int{200, 200, 200, 429, 429}
+		if fmt.Sprint(codes) != fmt.Sprint(want) {
+			t.Errorf("statuses = %v, want %v", codes, want)
+		}
+
+		if rec := serve("/", "198.51.100.1"); rec.Code != http.StatusOK {
+			t.Errorf("unthrottled path: status = %d, want %d", rec.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("many IPs", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			ip := fmt.Sprintf("198.51.100.%d", 10+i)
+			if rec := serve("/search?q=golang", ip); rec.Code != http.StatusOK {
+				t.Errorf("%s: status = %d, want %d", ip, rec.Code, http.StatusOK)
+			}
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var rec *httptest.ResponseRecorder
+		for i := 0; i < 4; i++ {
+			rec = serve("/api/search?q=golang", "198.51.100.2")
+		}
+
+		if rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+		}
+
+		var apiErr APIError
+		if err := json.NewDecoder(rec.Body).Decode(&apiErr); err != nil {
+			t.Fatal(err)
+		}
+
+		if apiErr.Error != errorMessages[http.StatusTooManyRequests] {
+			t.Errorf("error = %q, want %q", apiErr.Error, errorMessages[http.StatusTooManyRequests])
+		}
+	})
+}
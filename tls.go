@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const defaultAutocertCacheDir = "certs"
+
+// autocertTLSConfig obtains and renews certificates for the comma-separated
+// domains from Let's Encrypt, caching them in cacheDir so restarts don't
+// request new ones. Challenges are answered over TLS-ALPN on the same
+// listener, so nothing needs to listen on port 80.
+func autocertTLSConfig(domains, cacheDir string) *tls.Config {
+	if cacheDir == "" {
+		cacheDir = defaultAutocertCacheDir
+	}
+
+	var hosts []string
+	for _, domain := range strings.Split(domains, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			hosts = append(hosts, domain)
+		}
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(cacheDir),
+	}
+
+	return m.TLSConfig()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// All outbound requests go to a single Wikipedia host, so the pool keeps
+// many more idle connections per host than the default of 2. The idle
+// timeout matches http.DefaultTransport.
+const (
+	defaultMaxIdleConnsPerHost = 32
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+func newWikipediaTransport(maxIdleConnsPerHost int, idleConnTimeout time.Duration) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.IdleConnTimeout = idleConnTimeout
+	transport.ForceAttemptHTTP2 = true
+
+	if transport.MaxIdleConns < maxIdleConnsPerHost {
+		transport.MaxIdleConns = maxIdleConnsPerHost
+	}
+
+	return transport
+}
+
+// wikipediaTransportFromEnv builds the Wikipedia transport with the pool
+// settings from WIKI_MAX_IDLE_CONNS_PER_HOST and WIKI_IDLE_CONN_TIMEOUT.
+func wikipediaTransportFromEnv() *http.Transport {
+	return newWikipediaTransport(
+		envInt("WIKI_MAX_IDLE_CONNS_PER_HOST", defaultMaxIdleConnsPerHost),
+		envDuration("WIKI_IDLE_CONN_TIMEOUT", defaultIdleConnTimeout),
+	)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWikipediaTransportFromEnv(t *testing.T) {
+	t.Setenv("WIKI_MAX_IDLE_CONNS_PER_HOST", "64")
+	t.Setenv("WIKI_IDLE_CONN_TIMEOUT", "45s")
+
+	transport := wikipediaTransportFromEnv()
+
+	if transport.MaxIdleConnsPerHost != 64 {
+		t.Errorf("MaxIdleConnsPerHost = %d, want 64", transport.MaxIdleConnsPerHost)
+	}
+
+	if transport.MaxIdleConns < 64 {
+		t.Errorf("MaxIdleConns = %d, want at least 64", transport.MaxIdleConns)
+	}
+
+	if transport.IdleConnTimeout != 45*time.Second {
+		t.Errorf("IdleConnTimeout = %s, want 45s", transport.IdleConnTimeout)
+	}
+
+	if !transport.ForceAttemptHTTP2 {
+		t.Error("ForceAttemptHTTP2 = false, want true")
+	}
+}
+
+// BenchmarkWikipediaTransport sends concurrent requests to a TLS server and
+// reports how many TLS handshakes each transport needs per request. The
+// default transport only keeps two idle connections per host, so most
+// concurrent requests have to dial and handshake again.
+func BenchmarkWikipediaTransport(b *testing.B) {
+	transports := []struct {
+		name      string
+		transport *http.Transport
+	}{
+		{"default", http.DefaultTransport.(*http.Transport).Clone()},
+		{"tuned", newWikipediaTransport(defaultMaxIdleConnsPerHost, defaultIdleConnTimeout)},
+	}
+
+	for _, tt := range transports {
+		b.Run(tt.name, func(b *testing.B) {
+			var handshakes atomic.Int64
+
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Stand in for upstream latency so requests overlap.
+				time.Sleep(time.Millisecond)
+				io.WriteString(w, "ok")
+			}))
+			srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					handshakes.Add(1)
+				}
+			}
+			srv.StartTLS()
+			b.Cleanup(srv.Close)
+
+			// Trust the test server's certificate.
+			transport := tt.transport
+			transport.TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+			b.Cleanup(transport.CloseIdleConnections)
+
+			client := &http.Client{Transport: transport}
+
+			b.SetParallelism(16)
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					resp, err := client.Get(srv.URL)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+			})
+
+			b.ReportMetric(float64(handshakes.Load())/float64(b.N), "handshakes/op")
+		})
+	}
+}
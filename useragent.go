@@ -0,0 +1,22 @@
+package main
+
+import "fmt"
+
+const (
+	appVersion         = "1.0"
+	defaultContactInfo = "https://github.com/betterstack-community/wikipedia-demo"
+)
+
+var userAgent = buildUserAgent(defaultContactInfo)
+
+// buildUserAgent follows the Wikimedia User-Agent policy, identifying the
+// application, its version and a way to contact the operator. The version
+// carries the short VCS revision when the binary has one.
+func buildUserAgent(contact string) string {
+	version := appVersion
+	if len(buildInfo.Revision) >= 7 {
+		version += "+" + buildInfo.Revision[:7]
+	}
+
+	return fmt.Sprintf("wikipedia-demo/%s (%s)", version, contact)
+}
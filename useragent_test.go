@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBuildUserAgent(t *testing.T) {
+	saved := buildInfo
+	t.Cleanup(func() { buildInfo = saved })
+
+	tests := []struct {
+		revision string
+		want     string
+	}{
+		{"", "wikipedia-demo/1.0 (ops@example.com)"},
+		{"abc", "wikipedia-demo/1.0 (ops@example.com)"},
+		{"0123456789abcdef", "wikipedia-demo/1.0+0123456 (ops@example.com)"},
+	}
+
+	for _, tt := range tests {
+		buildInfo.Revision = tt.revision
+
+		if got := buildUserAgent("ops@example.com"); got != tt.want {
+			t.Errorf("revision %q: buildUserAgent() = %q, want %q", tt.revision, got, tt.want)
+		}
+	}
+}
+
+func TestSearchSendsUserAgent(t *testing.T) {
+	fake := newFakeWikipedia(t)
+	app := newTestApp(t, fake)
+
+	_, err := app.runSearch(httptest.NewRequest(http.MethodGet, "/search?q=golang", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := fake.lastSearch.Load().(*http.Request)
+	if got := req.Header.Get("User-Agent"); got != userAgent {
+		t.Errorf("User-Agent = %q, want %q", got, userAgent)
+	}
+}
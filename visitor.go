@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	visitorCookieName   = "visitorID"
+	visitorCookieMaxAge = 365 * 24 * time.Hour
+)
+
+// visitorSecret keys the HMAC that signs visitor cookies. It is replaced
+// from VISITOR_ID_SECRET at startup; the random default means cookies stop
+// validating whenever the process restarts.
+var visitorSecret = randomSecret()
+
+func randomSecret() []byte {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
+
+func signVisitorID(id string) string {
+	mac := hmac.New(sha256.New, visitorSecret)
+	mac.Write([]byte(id))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// visitorID returns the anonymous visitor ID from the signed cookie on r,
+// issuing a new cookie when it is missing or has been tampered with. Clients
+// that send "DNT: 1" are never given a cookie and get an empty ID.
+func visitorID(w http.ResponseWriter, r *http.Request) string {
+	if r.Header.Get("DNT") == "1" {
+		return ""
+	}
+
+	if cookie, err := r.Cookie(visitorCookieName); err == nil {
+		id, signature, ok := strings.Cut(cookie.Value, ".")
+		if ok && hmac.Equal([]byte(signature), []byte(signVisitorID(id))) {
+			return id
+		}
+	}
+
+	id := newCorrelationID()
+	if id == "" {
+		return ""
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     visitorCookieName,
+		Value:    id + "." + signVisitorID(id),
+		Path:     "/",
+		MaxAge:   int(visitorCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return id
+}
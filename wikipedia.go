@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultWikiAPIBase = "https://{lang}.wikipedia.org/w/api.php"
+
+type WikipediaClient struct {
+	HTTP    *http.Client
+	BaseURL string
+}
+
+func NewWikipediaClient(httpClient *http.Client, baseURL string) *WikipediaClient {
+	return &WikipediaClient{
+		HTTP:    httpClient,
+		BaseURL: baseURL,
+	}
+}
+
+func (c *WikipediaClient) newRequest(
+	ctx context.Context,
+	method, endpoint string,
+) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", userAgent)
+
+	return req, nil
+}
+
+// endpoint builds an API URL from BaseURL, substituting the "{lang}"
+// placeholder when the base contains one.
+func (c *WikipediaClient) endpoint(lang string, params url.Values) string {
+	u, err := url.Parse(strings.ReplaceAll(c.BaseURL, "{lang}", lang))
+	if err != nil {
+		return ""
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+func (c *WikipediaClient) Search(
+	ctx context.Context,
+	searchQuery string,
+	pageSize, resultsOffset int,
+	lang, sort, namespace string,
+) (*WikipediaSearchResponse, error) {
+	endpoint := c.endpoint(lang, url.Values{
+		"action":      {"query"},
+		"list":        {"search"},
+		"utf8":        {""},
+		"format":      {"json"},
+		"origin":      {"*"},
+		"srlimit":     {strconv.Itoa(pageSize)},
+		"srsearch":    {searchQuery},
+		"sroffset":    {strconv.Itoa(resultsOffset)},
+		"srsort":      {sort},
+		"srnamespace": {strings.ReplaceAll(namespace, ",", "|")},
+		"maxlag":      {strconv.Itoa(wikipediaMaxlag)},
+	})
+
+	wikiLog.Debugf(
+		"Wikipedia endpoint: %s (%s)",
+		redact(endpoint),
+		logFields(ctx),
+	)
+
+	var lastErr error
+
+	for attempt := 0; attempt <= maxSearchRetries; attempt++ {
+		if attempt > 0 {
+			delay := retryBackoff(attempt)
+
+			wikiLog.Warnf(
+				"Retrying Wikipedia search (attempt %d of %d) in %s: %s",
+				attempt,
+				maxSearchRetries,
+				delay,
+				redact(lastErr.Error()),
+			)
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		err := waitForRateLimit(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		searchResponse, retryable, err := c.fetchSearchThroughBreaker(ctx, endpoint)
+
+		var lagErr *maxlagError
+		if errors.As(err, &lagErr) {
+			searchResponse, retryable, err = c.retryAfterMaxlag(ctx, endpoint, lagErr)
+		}
+
+		if err == nil {
+			return searchResponse, nil
+		}
+
+		if !retryable {
+			return nil, err
+		}
+
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// fetchSearchThroughBreaker runs fetchSearch through wikipediaBreaker. Only
+// failures that say something about upstream health, such as network errors
+// and 5xx responses, count towards tripping the breaker.
+func (c *WikipediaClient) fetchSearchThroughBreaker(
+	ctx context.Context,
+	endpoint string,
+) (searchResponse *WikipediaSearchResponse, retryable bool, err error) {
+	_, breakerErr := wikipediaBreaker.Execute(func() (interface{}, error) {
+		searchResponse, retryable, err = c.fetchSearch(ctx, endpoint)
+		if err != nil && retryable {
+			return nil, err
+		}
+
+		return nil, nil
+	})
+	if breakerErr != nil && err == nil {
+		return nil, false, breakerError(breakerErr, breakerTimeout)
+	}
+
+	return searchResponse, retryable, err
+}
+
+func (c *WikipediaClient) fetchSearch(
+	ctx context.Context,
+	endpoint string,
+) (searchResponse *WikipediaSearchResponse, retryable bool, err error) {
+	ctx, span := tracer.Start(
+		ctx,
+		"wikipedia.search",
+		trace.WithSpanKind(trace.SpanKindClient),
+	)
+	defer span.End()
+
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}()
+
+	req, err := c.newRequest(ctx, http.MethodGet, endpoint)
+	if err != nil {
+		return nil, false, err
+	}
+
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	start := time.Now()
+
+	resp, err := c.HTTP.Do(req)
+
+	wikipediaRequestDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		wikipediaErrorsTotal.WithLabelValues("network_error").Inc()
+		return nil, ctx.Err() == nil, err
+	}
+
+	defer resp.Body.Close()
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+
+	if isMaxlagResponse(resp) {
+		wikipediaErrorsTotal.WithLabelValues(maxlagErrorCode).Inc()
+		return nil, false, newMaxlagError(resp)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		wikipediaErrorsTotal.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+
+		respData, _ := httputil.DumpResponse(resp, true)
+
+		return nil, isRetryableStatus(resp.StatusCode), fmt.Errorf(
+			"non 200 OK response from Wikipedia API: %s",
+			string(respData),
+		)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+
+	err = json.Unmarshal(body, &searchResponse)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// A literal null body decodes without error but leaves nothing to read.
+	if searchResponse == nil {
+		return nil, false, errors.New("empty search response")
+	}
+
+	for module, warning := range searchResponse.Warnings {
+		wikiLog.Warnf("Wikipedia API warning from '%s' module: %s", module, warning.Text)
+	}
+
+	// Wikipedia can also report maxlag as an API error in a 200 response.
+	if searchResponse.Error != nil && searchResponse.Error.Code == maxlagErrorCode {
+		wikipediaErrorsTotal.WithLabelValues(maxlagErrorCode).Inc()
+		return nil, false, newMaxlagError(resp)
+	}
+
+	if searchResponse.Error != nil {
+		wikiLog.Errorf(
+			"Wikipedia API returned error code '%s': %s",
+			searchResponse.Error.Code,
+			searchResponse.Error.Info,
+		)
+
+		return nil, false, searchResponse.Error
+	}
+
+	if problem := searchResponseShapeProblem(body); problem != "" {
+		wikiLog.Warnf(
+			"Unexpected Wikipedia search response shape, %s: %s (%s)",
+			problem,
+			redact(truncateBody(body, maxLoggedBodySize)),
+			logFields(ctx),
+		)
+	}
+
+	return searchResponse, false, nil
+}
+
+const maxLoggedBodySize = 512
+
+// searchResponseShapeProblem checks that the fields the app relies on are
+// present, since json.Unmarshal silently leaves missing ones at their zero
+// values and a changed response would otherwise just render as no results.
+func searchResponseShapeProblem(body []byte) string {
+	var shape struct {
+		Query *struct {
+			SearchInfo *struct {
+				TotalHits *int `json:"totalhits"`
+			} `json:"searchinfo"`
+			Search json.RawMessage `json:"search"`
+		} `json:"query"`
+	}
+
+	err := json.Unmarshal(body, &shape)
+	if err != nil {
+		return err.Error()
+	}
+
+	switch {
+	case shape.Query == nil:
+		return "missing query"
+	case shape.Query.SearchInfo == nil || shape.Query.SearchInfo.TotalHits == nil:
+		return "missing query.searchinfo.totalhits"
+	case *shape.Query.SearchInfo.TotalHits > 0 &&
+		(len(shape.Query.Search) == 0 || string(shape.Query.Search) == "null"):
+		return "missing query.search despite a non-zero totalhits"
+	}
+
+	return ""
+}
+
+// dedupeResults drops results whose pageid already appeared earlier in the
+// response, keeping the original order, and returns how many were dropped.
+func dedupeResults(resp *WikipediaSearchResponse) int {
+	seen := make(map[int]bool, len(resp.Query.Search))
+	results := resp.Query.Search[:0]
+
+	for _, result := range resp.Query.Search {
+		if seen[result.PageID] {
+			continue
+		}
+
+		seen[result.PageID] = true
+		results = append(results, result)
+	}
+
+	dropped := len(resp.Query.Search) - len(results)
+	resp.Query.Search = results
+
+	return dropped
+}
+
+func truncateBody(body []byte, n int) string {
+	if len(body) <= n {
+		return string(body)
+	}
+
+	return string(body[:n]) + "..."
+}
+
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+func retryBackoff(attempt int) time.Duration {
+	delay := retryBaseDelay << (attempt - 1)
+
+	return delay + time.Duration(rand.Int63n(int64(delay/2)))
+}
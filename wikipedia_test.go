@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSearchCancelledMidFlight(t *testing.T) {
+	received := make(chan struct{})
+	release := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	resetSearchState()
+	client := NewWikipediaClient(srv.Client(), srv.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := client.Search(ctx, "golang", 20, 0, defaultLanguage, "relevance", "0")
+		errc <- err
+	}()
+
+	<-received
+	cancel()
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Search() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Search() did not return after its context was cancelled")
+	}
+}
+
+func TestLookupsWaitForRateLimit(t *testing.T) {
+	fake := newFakeWikipedia(t)
+	resetSearchState()
+	client := NewWikipediaClient(fake.Client(), fake.URL)
+
+	lookups := map[string]func(ctx context.Context) error{
+		"PageInfo": func(ctx context.Context) error {
+			_, err := client.PageInfo(ctx, defaultLanguage, []string{"1"})
+			return err
+		},
+		"Article": func(ctx context.Context) error {
+			_, err := client.Article(ctx, defaultLanguage, 1)
+			return err
+		},
+	}
+
+	for name, lookup := range lookups {
+		t.Run(name, func(t *testing.T) {
+			wikipediaLimiter = newWikipediaLimiter(0.1)
+			wikipediaLimiter.Reserve()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			var rateErr *rateLimitError
+			if err := lookup(ctx); !errors.As(err, &rateErr) {
+				t.Errorf("error = %v, want a rate limit error", err)
+			}
+		})
+	}
+
+	if got := fake.requests.Load(); got != 0 {
+		t.Errorf("upstream requests = %d, want 0", got)
+	}
+
+	resetSearchState()
+}
+
+func TestSearchEncodesQuery(t *testing.T) {
+	fake := newFakeWikipedia(t)
+	app := newTestApp(t, fake)
+
+	for _, query := range []string{"C++ & C#", "rock & roll", "Zürich 東京"} {
+		t.Run(query, func(t *testing.T) {
+			target := "/search?" + url.Values{"q": {query}}.Encode()
+
+			search, err := app.runSearch(httptest.NewRequest(http.MethodGet, target, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := fake.lastSearch.Load().(*http.Request)
+			if got := req.URL.Query().Get("srsearch"); got != query {
+				t.Errorf("srsearch = %q, want %q", got, query)
+			}
+
+			if got := req.URL.Query().Get("srlimit"); got != strconv.Itoa(defaultPageSize) {
+				t.Errorf("srlimit = %q, want %d; the query leaked into other params", got, defaultPageSize)
+			}
+
+			results := search.Results.Query.Search
+			if len(results) != 3 || results[0].Title != query+" result 1" {
+				t.Errorf("results = %+v, want 3 results for %q", results, query)
+			}
+		})
+	}
+}
+
+func TestSearchMaxlagRetryFailureIsRetryable(t *testing.T) {
+	fake := newFakeWikipedia(t)
+	fake.maxlagSearches = 1
+	resetSearchState()
+
+	client := NewWikipediaClient(fake.Client(), fake.URL)
+
+	_, err := client.Search(context.Background(), "unknown", 20, 0, defaultLanguage, "relevance", "0")
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("Search() error = %v, want the upstream 500", err)
+	}
+
+	// The maxlag refusal, its single retry and then every regular retry.
+	if got, want := fake.searches.Load(), int64(2+maxSearchRetries); got != want {
+		t.Errorf("upstream searches = %d, want %d", got, want)
+	}
+
+	// Only the 500s count towards tripping the breaker.
+	if got, want := wikipediaBreaker.Counts().TotalFailures, uint32(1+maxSearchRetries); got != want {
+		t.Errorf("breaker failures = %d, want %d", got, want)
+	}
+}
+
+func TestSearchNullBody(t *testing.T) {
+	var requests atomic.Int64
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "null")
+	}))
+	defer srv.Close()
+
+	resetSearchState()
+	client := NewWikipediaClient(srv.Client(), srv.URL)
+
+	_, err := client.Search(context.Background(), "golang", 20, 0, defaultLanguage, "relevance", "0")
+	if err == nil || !strings.Contains(err.Error(), "empty search response") {
+		t.Fatalf("Search() error = %v, want an empty search response error", err)
+	}
+
+	if got := requests.Load(); got != 1 {
+		t.Errorf("upstream requests = %d, want 1 (not retried)", got)
+	}
+}